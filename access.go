@@ -0,0 +1,209 @@
+package netclip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// AccessConfig holds the CIDR rules that decide which clients can reach netclip
+type AccessConfig struct {
+	TrustedProxies []string   `yaml:"trusted_proxies"`
+	Read           AccessRule `yaml:"read"`
+	Write          AccessRule `yaml:"write"`
+	Admin          AccessRule `yaml:"admin"`
+}
+
+// AccessRule lists the networks allowed or denied for a kind of request.
+// Deny entries win over allow entries, and an empty allow list lets in
+// everyone who isn't denied.
+type AccessRule struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// AccessPolicy is the parsed form of AccessConfig
+type AccessPolicy struct {
+	trustedProxies []netip.Prefix
+	read           accessRule
+	write          accessRule
+	admin          accessRule
+}
+
+type accessRule struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewAccessPolicy parses the rules in the given config
+func NewAccessPolicy(config AccessConfig) (*AccessPolicy, error) {
+	var err error
+	policy := &AccessPolicy{}
+
+	if policy.trustedProxies, err = parsePrefixes(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %w", err)
+	}
+	if policy.read, err = parseAccessRule(config.Read); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	if policy.write, err = parseAccessRule(config.Write); err != nil {
+		return nil, fmt.Errorf("write: %w", err)
+	}
+	if policy.admin, err = parseAccessRule(config.Admin); err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+
+	return policy, nil
+}
+
+func parseAccessRule(rule AccessRule) (accessRule, error) {
+	allow, err := parsePrefixes(rule.Allow)
+	if err != nil {
+		return accessRule{}, fmt.Errorf("allow: %w", err)
+	}
+	deny, err := parsePrefixes(rule.Deny)
+	if err != nil {
+		return accessRule{}, fmt.Errorf("deny: %w", err)
+	}
+	return accessRule{allow: allow, deny: deny}, nil
+}
+
+// parsePrefixes accepts CIDR blocks as well as bare addresses, which are
+// treated as a single host.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r accessRule) allows(addr netip.Addr) bool {
+	if containsAddr(r.deny, addr) {
+		return false
+	}
+	return len(r.allow) == 0 || containsAddr(r.allow, addr)
+}
+
+// ClientAddr works out the address of the client that made the request.
+// X-Forwarded-For is only honored when the request came from a trusted proxy,
+// and then the rightmost address that isn't another trusted proxy wins.
+func (p *AccessPolicy) ClientAddr(r *http.Request) (netip.Addr, bool) {
+	remote, ok := remoteAddr(r)
+	if !ok || !containsAddr(p.trustedProxies, remote) {
+		return remote, ok
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !containsAddr(p.trustedProxies, client) {
+			break
+		}
+	}
+
+	return client, true
+}
+
+// Allowed reports whether the client may make the request. GET and HEAD
+// requests are checked against the read rules and everything else against
+// the write rules.
+func (p *AccessPolicy) Allowed(r *http.Request) bool {
+	addr, ok := p.ClientAddr(r)
+	if !ok {
+		return false
+	}
+	if isReadRequest(r) {
+		return p.read.allows(addr)
+	}
+	return p.write.allows(addr)
+}
+
+// Middleware rejects requests from clients the policy doesn't allow before
+// they reach the next handler.
+func (p *AccessPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.Allowed(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if addr, ok := p.ClientAddr(r); ok {
+			r = withClientAddr(r, addr)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminAllowed reports whether the client may use the admin pages. Without
+// any admin allow rules only clients on the same host get in, apart from
+// those on a Unix socket.
+func (p *AccessPolicy) AdminAllowed(r *http.Request) bool {
+	addr, ok := p.ClientAddr(r)
+	if !ok {
+		return false
+	}
+	if len(p.admin.allow) == 0 && (!addr.IsLoopback() || isSocketPeer(r)) {
+		return false
+	}
+	return p.admin.allows(addr)
+}
+
+// AdminMiddleware rejects clients the admin rules don't allow
+func (p *AccessPolicy) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.AdminAllowed(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isReadRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
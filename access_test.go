@@ -0,0 +1,147 @@
+package netclip_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequest(t *testing.T, method, remoteAddr string) *http.Request {
+	req, err := http.NewRequest(method, "/", nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestAccessPolicyAllowsEveryoneByDefault(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{})
+	assert.NoError(t, err)
+
+	assert.True(t, policy.Allowed(newRequest(t, "GET", "203.0.113.9:1234")))
+	assert.True(t, policy.Allowed(newRequest(t, "POST", "203.0.113.9:1234")))
+}
+
+func TestAccessPolicySeparateReadAndWriteRules(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		Read:  netclip.AccessRule{Allow: []string{"192.168.0.0/16"}},
+		Write: netclip.AccessRule{Allow: []string{"192.168.1.0/24"}},
+	})
+	assert.NoError(t, err)
+
+	// Office LAN can read and write
+	assert.True(t, policy.Allowed(newRequest(t, "GET", "192.168.1.20:1234")))
+	assert.True(t, policy.Allowed(newRequest(t, "POST", "192.168.1.20:1234")))
+
+	// Guest subnet can only read
+	assert.True(t, policy.Allowed(newRequest(t, "GET", "192.168.50.20:1234")))
+	assert.False(t, policy.Allowed(newRequest(t, "POST", "192.168.50.20:1234")))
+
+	// Everyone else is out
+	assert.False(t, policy.Allowed(newRequest(t, "GET", "10.0.0.5:1234")))
+}
+
+func TestAccessPolicyDenyWinsOverAllow(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		Read: netclip.AccessRule{
+			Allow: []string{"10.0.0.0/8"},
+			Deny:  []string{"10.0.5.0/24", "10.0.0.1"},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, policy.Allowed(newRequest(t, "GET", "10.0.6.1:1234")))
+	assert.False(t, policy.Allowed(newRequest(t, "GET", "10.0.5.1:1234")))
+	assert.False(t, policy.Allowed(newRequest(t, "GET", "10.0.0.1:1234")))
+}
+
+func TestAccessPolicyIPv6(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		Read: netclip.AccessRule{Allow: []string{"fd00::/8", "127.0.0.1"}},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, policy.Allowed(newRequest(t, "GET", "[fd12::1]:1234")))
+	assert.False(t, policy.Allowed(newRequest(t, "GET", "[2001:db8::1]:1234")))
+	// IPv4-mapped addresses match IPv4 rules
+	assert.True(t, policy.Allowed(newRequest(t, "GET", "[::ffff:127.0.0.1]:1234")))
+}
+
+func TestAccessPolicyInvalidRules(t *testing.T) {
+	_, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		Write: netclip.AccessRule{Deny: []string{"not-a-network"}},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "write")
+}
+
+func TestAccessPolicyIgnoresForwardedForFromUntrustedClients(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		TrustedProxies: []string{"10.0.0.1"},
+	})
+	assert.NoError(t, err)
+
+	req := newRequest(t, "GET", "192.168.1.20:1234")
+	req.Header.Set("X-Forwarded-For", "172.16.0.9")
+
+	addr, ok := policy.ClientAddr(req)
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.20", addr.String())
+}
+
+func TestAccessPolicyUsesForwardedForFromTrustedProxies(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		TrustedProxies: []string{"10.0.0.0/24"},
+	})
+	assert.NoError(t, err)
+
+	// A spoofed entry from the client sits to the left of the real one
+	req := newRequest(t, "GET", "10.0.0.1:1234")
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 192.168.1.20, 10.0.0.2")
+
+	addr, ok := policy.ClientAddr(req)
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.20", addr.String())
+}
+
+func TestAccessPolicyMiddleware(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		Write: netclip.AccessRule{Allow: []string{"127.0.0.1"}},
+	})
+	assert.NoError(t, err)
+
+	handler := policy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest(t, "POST", "192.168.1.20:1234"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest(t, "POST", "127.0.0.1:1234"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func TestAccessPolicyAdminDefaultsToLoopback(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{})
+	assert.NoError(t, err)
+
+	assert.True(t, policy.AdminAllowed(newRequest(t, "GET", "127.0.0.1:1234")))
+	assert.True(t, policy.AdminAllowed(newRequest(t, "GET", "[::1]:1234")))
+	assert.False(t, policy.AdminAllowed(newRequest(t, "GET", "192.168.1.20:1234")))
+}
+
+func TestAccessPolicyAdminRules(t *testing.T) {
+	policy, err := netclip.NewAccessPolicy(netclip.AccessConfig{
+		Admin: netclip.AccessRule{Allow: []string{"192.168.1.20"}},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, policy.AdminAllowed(newRequest(t, "GET", "192.168.1.20:1234")))
+	assert.False(t, policy.AdminAllowed(newRequest(t, "GET", "192.168.1.21:1234")))
+	assert.False(t, policy.AdminAllowed(newRequest(t, "GET", "127.0.0.1:1234")))
+}
@@ -0,0 +1,113 @@
+package netclip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig gets and renews certificates from an ACME server, such as
+// Let's Encrypt or an internal step-ca
+type ACMEConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DirectoryURL is the ACME server's directory. It defaults to Let's
+	// Encrypt.
+	DirectoryURL string `yaml:"directory_url"`
+	Email        string `yaml:"email"`
+	// Domains are the host names to get certificates for. Requests for any
+	// other name are refused.
+	Domains []string `yaml:"domains"`
+	// Challenge is how the server proves it owns the domains: tls-alpn-01,
+	// the default, on the HTTPS port, or http-01 on HTTPPort
+	Challenge string `yaml:"challenge"`
+	HTTPPort  string `yaml:"http_port"`
+	// CacheDir keeps the account key and certificates between restarts
+	CacheDir string `yaml:"cache_dir"`
+	// CARoot is a PEM file with the root certificate of the ACME server's
+	// own HTTPS certificate, for internal servers
+	CARoot string `yaml:"ca_root"`
+}
+
+// ACME challenge types
+const (
+	ChallengeTLSALPN = "tls-alpn-01"
+	ChallengeHTTP    = "http-01"
+)
+
+// Validate checks the settings before any certificates are requested
+func (c ACMEConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Domains) == 0 {
+		return errors.New("acme needs at least one domain")
+	}
+	switch c.Challenge {
+	case "", ChallengeTLSALPN, ChallengeHTTP:
+	default:
+		return fmt.Errorf("unknown acme challenge %q, use %s or %s", c.Challenge, ChallengeTLSALPN, ChallengeHTTP)
+	}
+	return nil
+}
+
+// usesHTTPChallenge reports whether a port needs to be opened for http-01
+func (c ACMEConfig) usesHTTPChallenge() bool {
+	return c.Challenge == ChallengeHTTP
+}
+
+func (c ACMEConfig) httpPort() string {
+	if c.HTTPPort == "" {
+		return "80"
+	}
+	return c.HTTPPort
+}
+
+// newACMEManager sets up an autocert manager from the config
+func newACMEManager(config ACMEConfig) (*autocert.Manager, error) {
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("could not find a directory for certificates, set acme.cache_dir: %w", err)
+		}
+		cacheDir = filepath.Join(configDir, "netclip", "acme")
+	}
+
+	client := &acme.Client{DirectoryURL: config.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+
+	if config.CARoot != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(config.CARoot)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CARoot)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Client:     client,
+		Email:      config.Email,
+	}, nil
+}
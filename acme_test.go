@@ -0,0 +1,386 @@
+package netclip_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// acmeStandIn is a small ACME server for tests. It really checks the
+// challenges, by connecting to the server under test, before it issues
+// certificates from its own root.
+type acmeStandIn struct {
+	t          *testing.T
+	server     *httptest.Server
+	challenges []string
+	// tlsAddr and httpAddr are where the server under test answers
+	// tls-alpn-01 and http-01 challenges
+	tlsAddr, httpAddr string
+
+	rootKey  *ecdsa.PrivateKey
+	root     *x509.Certificate
+	rootDER  []byte
+	mu       sync.Mutex
+	thumb    string
+	orders   []*acmeOrder
+	issued   map[int][]byte
+	accepted []string
+}
+
+type acmeOrder struct {
+	domain string
+	token  string
+	status string
+}
+
+func newACMEStandIn(t *testing.T, challenges ...string) *acmeStandIn {
+	ca := &acmeStandIn{t: t, challenges: challenges, issued: make(map[int][]byte)}
+
+	var err error
+	ca.rootKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ACME stand-in root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca.rootDER, err = x509.CreateCertificate(rand.Reader, template, template, &ca.rootKey.PublicKey, ca.rootKey)
+	assert.NoError(t, err)
+	ca.root, err = x509.ParseCertificate(ca.rootDER)
+	assert.NoError(t, err)
+
+	ca.server = httptest.NewTLSServer(http.HandlerFunc(ca.handle))
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+// rootFile writes the certificate of the stand-in's own HTTPS server, for
+// acme.ca_root
+func (ca *acmeStandIn) rootFile() string {
+	path := filepath.Join(ca.t.TempDir(), "acme-root.crt")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.server.Certificate().Raw})
+	assert.NoError(ca.t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func (ca *acmeStandIn) url(format string, args ...any) string {
+	return ca.server.URL + fmt.Sprintf(format, args...)
+}
+
+// payload reads the JSON payload out of a JWS request body. The stand-in
+// trusts the signature; it's only here to exercise the client.
+func (ca *acmeStandIn) payload(r *http.Request, v any) (protected map[string]any) {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	_ = json.Unmarshal(header, &protected)
+	if data, _ := base64.RawURLEncoding.DecodeString(jws.Payload); len(data) > 0 && v != nil {
+		_ = json.Unmarshal(data, v)
+	}
+	return protected
+}
+
+func (ca *acmeStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(time.Now().UnixNano(), 10))))
+	w.Header().Set("Content-Type", "application/json")
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id := 0
+	if len(parts) > 1 {
+		id, _ = strconv.Atoi(parts[len(parts)-1])
+	}
+
+	switch parts[0] {
+	case "directory":
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"newNonce":   ca.url("/new-nonce"),
+			"newAccount": ca.url("/new-account"),
+			"newOrder":   ca.url("/new-order"),
+		})
+
+	case "new-nonce":
+
+	case "new-account":
+		protected := ca.payload(r, nil)
+		jwk, _ := protected["jwk"].(map[string]any)
+		// The thumbprint of an EC key is the hash of its members in
+		// lexical order, as RFC 7638 requires
+		canonical := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])
+		sum := sha256.Sum256([]byte(canonical))
+		ca.thumb = base64.RawURLEncoding.EncodeToString(sum[:])
+
+		w.Header().Set("Location", ca.url("/account/1"))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"status": "valid"}`))
+
+	case "new-order":
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		ca.payload(r, &req)
+		order := &acmeOrder{domain: req.Identifiers[0].Value, token: base64.RawURLEncoding.EncodeToString([]byte(req.Identifiers[0].Value + strconv.Itoa(len(ca.orders)))), status: "pending"}
+		ca.orders = append(ca.orders, order)
+
+		w.Header().Set("Location", ca.url("/order/%d", len(ca.orders)-1))
+		w.WriteHeader(http.StatusCreated)
+		ca.writeOrder(w, len(ca.orders)-1)
+
+	case "order":
+		ca.payload(r, nil)
+		ca.writeOrder(w, id)
+
+	case "authz":
+		ca.payload(r, nil)
+		order := ca.orders[id]
+		var challenges []map[string]string
+		for _, typ := range ca.challenges {
+			challenges = append(challenges, map[string]string{
+				"type":   typ,
+				"url":    ca.url("/challenge/%s/%d", typ, id),
+				"token":  order.token,
+				"status": order.status,
+			})
+		}
+		authzStatus := order.status
+		if authzStatus == "ready" || authzStatus == "issued" {
+			authzStatus = "valid"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status":     authzStatus,
+			"identifier": map[string]string{"type": "dns", "value": order.domain},
+			"challenges": challenges,
+		})
+
+	case "challenge":
+		ca.payload(r, nil)
+		order, typ := ca.orders[id], parts[1]
+		ca.accepted = append(ca.accepted, typ)
+		if err := ca.check(typ, order); err != nil {
+			ca.t.Logf("%s challenge failed: %v", typ, err)
+			order.status = "invalid"
+		} else {
+			order.status = "ready"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"type": typ, "url": ca.server.URL + r.URL.Path, "token": order.token, "status": "processing"})
+
+	case "finalize":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		ca.payload(r, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		leaf := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(id) + 2),
+			Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		ca.issued[id], err = x509.CreateCertificate(rand.Reader, leaf, ca.root, csr.PublicKey, ca.rootKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ca.orders[id].status = "issued"
+		ca.writeOrder(w, id)
+
+	case "cert":
+		ca.payload(r, nil)
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.issued[id]})
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.rootDER})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *acmeStandIn) writeOrder(w io.Writer, id int) {
+	order := ca.orders[id]
+	status := order.status
+	body := map[string]any{
+		"identifiers":    []map[string]string{{"type": "dns", "value": order.domain}},
+		"authorizations": []string{ca.url("/authz/%d", id)},
+		"finalize":       ca.url("/finalize/%d", id),
+	}
+	if status == "issued" {
+		status = "valid"
+		body["certificate"] = ca.url("/cert/%d", id)
+	}
+	body["status"] = status
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// check connects to the server under test to see that it answers the
+// challenge for the order
+func (ca *acmeStandIn) check(typ string, order *acmeOrder) error {
+	keyAuth := order.token + "." + ca.thumb
+
+	switch typ {
+	case "tls-alpn-01":
+		conn, err := tls.Dial("tcp", ca.tlsAddr, &tls.Config{
+			ServerName:         order.domain,
+			NextProtos:         []string{"acme-tls/1"},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		want := sha256.Sum256([]byte(keyAuth))
+		for _, ext := range conn.ConnectionState().PeerCertificates[0].Extensions {
+			var got []byte
+			if ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+				if _, err := asn1.Unmarshal(ext.Value, &got); err == nil && bytes.Equal(got, want[:]) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no acmeIdentifier for %s", order.domain)
+
+	case "http-01":
+		req, err := http.NewRequest("GET", "http://"+ca.httpAddr+"/.well-known/acme-challenge/"+order.token, nil)
+		if err != nil {
+			return err
+		}
+		req.Host = order.domain
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != keyAuth {
+			return fmt.Errorf("got %q for the http-01 challenge", body)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown challenge %s", typ)
+}
+
+// fetchWithACME serves an app over HTTPS with certificates from the
+// stand-in and fetches the index page as netclip.test
+func fetchWithACME(t *testing.T, ca *acmeStandIn, config netclip.ACMEConfig) *x509.Certificate {
+	port := freePort(t)
+	ca.tlsAddr = "127.0.0.1:" + port
+	if config.Challenge == netclip.ChallengeHTTP {
+		config.HTTPPort = freePort(t)
+		ca.httpAddr = "127.0.0.1:" + config.HTTPPort
+	}
+
+	config.Enabled = true
+	config.DirectoryURL = ca.url("/directory")
+	config.Domains = []string{"netclip.test"}
+	config.CARoot = ca.rootFile()
+	if config.CacheDir == "" {
+		config.CacheDir = t.TempDir()
+	}
+
+	server := &netclip.HTTPServer{Addresses: []string{"127.0.0.1"}, Port: port, ACME: config}
+	ln, err := server.Listen(context.Background())
+	assert.NoError(t, err)
+
+	app := newApp(t)
+	go func() {
+		_ = server.Serve(ln, app)
+	}()
+	defer server.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.root)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: "netclip.test", RootCAs: roots},
+		},
+	}
+
+	resp, err := client.Get("https://" + ca.tlsAddr + "/")
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	return resp.TLS.PeerCertificates[0]
+}
+
+func TestACMEWithTLSALPNChallenge(t *testing.T) {
+	ca := newACMEStandIn(t, "tls-alpn-01")
+	cacheDir := t.TempDir()
+
+	cert := fetchWithACME(t, ca, netclip.ACMEConfig{Email: "ops@example.com", CacheDir: cacheDir})
+	assert.Equal(t, []string{"netclip.test"}, cert.DNSNames)
+	assert.Equal(t, []string{"tls-alpn-01"}, ca.accepted)
+
+	// The certificate is cached, so a restart doesn't ask for another
+	cached := fetchWithACME(t, ca, netclip.ACMEConfig{CacheDir: cacheDir})
+	assert.Equal(t, cert.SerialNumber, cached.SerialNumber)
+	assert.Len(t, ca.orders, 1)
+}
+
+func TestACMEWithHTTPChallenge(t *testing.T) {
+	ca := newACMEStandIn(t, "http-01")
+
+	cert := fetchWithACME(t, ca, netclip.ACMEConfig{Challenge: netclip.ChallengeHTTP})
+	assert.Equal(t, []string{"netclip.test"}, cert.DNSNames)
+	assert.Equal(t, []string{"http-01"}, ca.accepted)
+}
+
+func TestACMEConfigValidate(t *testing.T) {
+	assert.NoError(t, netclip.ACMEConfig{}.Validate())
+	assert.NoError(t, netclip.ACMEConfig{Enabled: true, Domains: []string{"netclip.example.com"}}.Validate())
+	assert.Error(t, netclip.ACMEConfig{Enabled: true}.Validate())
+	assert.Error(t, netclip.ACMEConfig{Enabled: true, Domains: []string{"netclip.example.com"}, Challenge: "dns-01"}.Validate())
+
+	_, err := netclip.NewApp(netclip.Config{
+		AutoTLS: netclip.AutoTLSConfig{Enabled: true},
+		ACME:    netclip.ACMEConfig{Enabled: true, Domains: []string{"netclip.example.com"}},
+	})
+	assert.Error(t, err)
+}
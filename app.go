@@ -1,97 +1,696 @@
 package netclip
 
 import (
+	"context"
 	"crypto/tls"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"text/template"
+	"sync"
 	"time"
 
+	"golang.org/x/crypto/acme/autocert"
+	"tailscale.com/client/local"
 	"tailscale.com/tsnet"
 )
 
-var dataStore = NewDataStore()
-
 //go:embed static
 var staticFiles embed.FS
 
+// templateFuncs are the helpers available to page templates
+var templateFuncs = template.FuncMap{
+	"isE2E": IsE2E,
+	"join":  strings.Join,
+}
+
 // AppVersion holds the application version
 var AppVersion = "0.6.1"
 
+// ShutdownTimeout is how long Run waits for in-flight requests to finish
+// when it stops
+var ShutdownTimeout = 5 * time.Second
+
 // Server interface for different server types
 type Server interface {
-	Listen() (net.Listener, error)
-	Serve(ln net.Listener) error
+	// Listen starts listening, giving up if ctx is done first
+	Listen(ctx context.Context) (net.Listener, error)
+	// Serve handles connections from ln with handler until Shutdown
+	Serve(ln net.Listener, handler http.Handler) error
+	// Shutdown stops accepting connections and waits for in-flight
+	// requests until the context is done
+	Shutdown(ctx context.Context) error
+}
+
+// httpServerHolder lazily creates the http.Server behind a Server so it can
+// be shut down whether or not Serve has started yet
+type httpServerHolder struct {
+	once sync.Once
+	srv  *http.Server
+}
+
+func (h *httpServerHolder) httpServer() *http.Server {
+	h.once.Do(func() {
+		h.srv = &http.Server{}
+	})
+	return h.srv
 }
 
 // CreateServer creates the appropriate server type based on configuration
 func CreateServer(config Config, authKey string) Server {
 	if config.Tailscale.Enabled {
-		return &TSNetServer{
-			Hostname: config.Tailscale.Hostname,
-			AuthKey:  authKey,
-			UseTLS:   config.Tailscale.UseTLS,
-		}
+		return newTSNetServer(config.Tailscale, authKey)
 	}
 	return &HTTPServer{
-		Port:     config.Port,
-		CertFile: config.CertFile,
-		KeyFile:  config.KeyFile,
+		Addresses: config.Addresses,
+		Port:      config.Port,
+		CertFile:  config.CertFile,
+		KeyFile:   config.KeyFile,
+		ACME:      config.ACME,
+	}
+}
+
+// App is a netclip instance. It owns its clips, audit log, templates and
+// settings, and serves them as an http.Handler, so several can run in one
+// process or be embedded in another program.
+type App struct {
+	clips      DataStore
+	auditLog   *AuditLog
+	templates  *template.Template
+	csrfSecret []byte
+	autoCerts  *AutoCerts
+	handler    *liveHandler
+	// passphraseChecks holds a slot for each passphrase being checked
+	passphraseChecks chan struct{}
+
+	// mu guards the settings a reload can change
+	mu        sync.RWMutex
+	config    Config
+	mode      Mode
+	limiter   *RateLimiter
+	listeners []listener
+}
+
+// listener is a configured listener and the handler that serves it
+type listener struct {
+	config  ListenerConfig
+	handler *liveHandler
+}
+
+// NewApp sets up an app from the config, loading saved clips and opening
+// the audit log. Close it when you're done to save its clips.
+func NewApp(config Config) (*App, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	templates, err := template.New("").Funcs(templateFuncs).ParseFS(staticFiles, "static/index.html", "static/clip.html")
+	if err != nil {
+		return nil, fmt.Errorf("could not parse templates: %w", err)
+	}
+
+	a := &App{
+		clips:     NewDataStore(),
+		templates: templates,
+		// The secret changes every time the app starts, which invalidates
+		// pages left open across a restart
+		csrfSecret: randomBytes(32),
+		handler:    &liveHandler{},
+	}
+
+	checks := config.MaxPassphraseChecks
+	if checks == 0 {
+		checks = defaultPassphraseChecks
+	}
+	a.passphraseChecks = make(chan struct{}, checks)
+
+	if config.AutoTLS.Enabled {
+		a.autoCerts, err = EnsureAutoCerts(config.AutoTLS)
+		if err != nil {
+			return nil, err
+		}
+		config = useAutoCerts(config, a.autoCerts)
+	}
+
+	limiter := NewRateLimiter(config.RateLimit)
+	handler, listenerHandlers, err := a.newHandlers(config, limiter)
+	if err != nil {
+		return nil, err
+	}
+	a.apply(config, limiter, handler, listenerHandlers)
+
+	if config.DataFile != "" {
+		keyring, err := LoadKeyring(config.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("could not load encryption keys: %w", err)
+		}
+		if keyring == nil {
+			log.Printf("No encryption key configured, clips in %s are stored in plain text", config.DataFile)
+		}
+		if err := a.clips.UseFile(config.DataFile, keyring); err != nil {
+			return nil, fmt.Errorf("could not load clips: %w", err)
+		}
+	}
+
+	a.auditLog, err = OpenAuditLog(config.Audit.File)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+
+	return a, nil
+}
+
+// validateConfig checks settings that depend on each other
+func validateConfig(config Config) error {
+	if err := config.Mode.Validate(); err != nil {
+		return err
+	}
+	if err := validateListeners(config.Listeners, config.ACME); err != nil {
+		return err
+	}
+	if err := config.ACME.Validate(); err != nil {
+		return err
+	}
+	if err := config.Tailscale.Validate(); err != nil {
+		return err
+	}
+	if config.MaxPassphraseChecks < 0 {
+		return errors.New("max_passphrase_checks can't be negative")
+	}
+	if config.ACME.Enabled {
+		if config.AutoTLS.Enabled {
+			return errors.New("choose either auto_tls or acme")
+		}
+		if len(config.Listeners) == 0 && (config.CertFile != "" || config.KeyFile != "") {
+			return errors.New("choose either acme or cert_file and key_file")
+		}
+	}
+	return nil
+}
+
+// useAutoCerts gives the generated certificate to the top-level server and
+// every listener on a port that doesn't have its own
+func useAutoCerts(config Config, certs *AutoCerts) Config {
+	if config.CertFile == "" && config.KeyFile == "" {
+		config.CertFile, config.KeyFile = certs.CertFile, certs.KeyFile
+	}
+
+	config.Listeners = slices.Clone(config.Listeners)
+	for i, l := range config.Listeners {
+		if l.Port != "" && l.CertFile == "" && l.KeyFile == "" {
+			config.Listeners[i].CertFile, config.Listeners[i].KeyFile = certs.CertFile, certs.KeyFile
+		}
+	}
+	return config
+}
+
+// newHandlers builds the top-level handler and one for each listener, with
+// their access rules and modes
+func (a *App) newHandlers(config Config, limiter *RateLimiter) (http.Handler, []http.Handler, error) {
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeNormal
+	}
+
+	handler, err := a.newHandler(config.Access, mode, limiter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var listenerHandlers []http.Handler
+	for _, l := range config.Listeners {
+		access, listenerMode := config.Access, mode
+		if l.Access != nil {
+			access = *l.Access
+		}
+		if l.Mode != "" {
+			listenerMode = l.Mode
+		}
+
+		h, err := a.newHandler(access, listenerMode, limiter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("listener %s: %w", l, err)
+		}
+		listenerHandlers = append(listenerHandlers, h)
+	}
+	return handler, listenerHandlers, nil
+}
+
+// apply makes a config the running one, swapping in its handlers
+func (a *App) apply(config Config, limiter *RateLimiter, handler http.Handler, listenerHandlers []http.Handler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.config = config
+	a.mode = config.Mode
+	if a.mode == "" {
+		a.mode = ModeNormal
+	}
+	a.limiter = limiter
+
+	a.handler.set(handler)
+	for i, h := range listenerHandlers {
+		if i == len(a.listeners) {
+			a.listeners = append(a.listeners, listener{handler: &liveHandler{}})
+		}
+		a.listeners[i].config = config.Listeners[i]
+		a.listeners[i].handler.set(h)
+	}
+}
+
+// settings returns the running config
+func (a *App) settings() Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.config
+}
+
+// currentMode returns the top-level mode
+func (a *App) currentMode() Mode {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.mode
+}
+
+// newHandler routes requests to the app's handlers behind the access rules
+// and the rate limits, serving them under the given mode
+func (a *App) newHandler(access AccessConfig, mode Mode, limiter *RateLimiter) (http.Handler, error) {
+	policy, err := NewAccessPolicy(access)
+	if err != nil {
+		return nil, err
+	}
+
+	protect := func(handler http.HandlerFunc) http.Handler {
+		return policy.Middleware(limiter.Middleware(handler))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", protect(a.IndexHandler))
+	mux.Handle("/save", protect(a.SaveHandler))
+	mux.Handle("/delete", protect(a.DeleteHandler))
+	mux.Handle("/clip/{key}", protect(a.ClipHandler))
+	mux.Handle("/raw/{key}", protect(a.RawHandler))
+	mux.Handle("/api/clips", protect(a.APIClipsHandler))
+	// Every page load fetches the static files, so they don't count
+	// against the rate limit
+	mux.Handle("/static/", policy.Middleware(http.HandlerFunc(StaticFileHandler)))
+	if a.autoCerts != nil {
+		mux.Handle("/ca.crt", protect(a.CACertHandler))
+	}
+	mux.Handle("/admin/audit", protect(policy.AdminMiddleware(http.HandlerFunc(a.AuditHandler)).ServeHTTP))
+	return withMode(mode, mux), nil
+}
+
+// ServeHTTP routes a request to the app's handlers with the top-level
+// access rules and mode
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.handler.ServeHTTP(w, r)
+}
+
+// Close saves the app's clips and closes its audit log
+func (a *App) Close() error {
+	err := a.clips.Flush()
+	if closeErr := a.auditLog.Close(); err == nil {
+		err = closeErr
 	}
+	return err
 }
 
-// setupHandlers registers all HTTP handlers
-func setupHandlers() {
-	http.HandleFunc("/", IndexHandler)
-	http.HandleFunc("/save", SaveHandler)
-	http.HandleFunc("/delete", DeleteHandler)
-	http.HandleFunc("/static/", StaticFileHandler)
+// Run serves the app with the provided Server implementation and blocks
+// until ctx is cancelled or the server fails. It then stops taking new
+// connections and waits up to ShutdownTimeout for in-flight requests. The
+// app is left open; Close it afterwards.
+func (a *App) Run(ctx context.Context, server Server) error {
+	return a.serve(ctx, []Server{server}, []http.Handler{a})
+}
+
+// RunListeners serves the app on every configured listener, or on the
+// top-level port or tailnet when there are none, like Run. authKey joins
+// the tailnet without a login prompt.
+func (a *App) RunListeners(ctx context.Context, authKey string) error {
+	a.mu.RLock()
+	config, listeners := a.config, slices.Clone(a.listeners)
+	a.mu.RUnlock()
+
+	if len(listeners) == 0 {
+		return a.Run(ctx, CreateServer(config, authKey))
+	}
+
+	var servers []Server
+	var handlers []http.Handler
+	for _, l := range listeners {
+		servers = append(servers, createListenerServer(l.config, config, authKey))
+		handlers = append(handlers, l.handler)
+	}
+	return a.serve(ctx, servers, handlers)
+}
+
+// serve runs each server with its handler until ctx is cancelled or one of
+// them fails, then shuts them all down
+func (a *App) serve(ctx context.Context, servers []Server, handlers []http.Handler) error {
+	shutdown := func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+
+		for _, server := range servers {
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Error shutting down: %v", err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if a.autoCerts != nil {
+		go a.renewAutoCerts(ctx)
+	}
+
+	// Each server starts on its own, so one that's slow to listen, like a
+	// node joining the tailnet, doesn't hold up the others. Servers that
+	// are still listening give up when ctx is cancelled.
+	var listening sync.WaitGroup
+	serveErr := make(chan error, len(servers))
+	for i, server := range servers {
+		listening.Add(1)
+		go func() {
+			ln, err := server.Listen(ctx)
+			listening.Done()
+			if err != nil {
+				serveErr <- fmt.Errorf("could not create listener: %w", err)
+				return
+			}
+			// Serve returns straight away, closing ln, if the server has
+			// already been shut down
+			if err := server.Serve(ln, handlers[i]); err != nil {
+				serveErr <- fmt.Errorf("could not start server: %w", err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		log.Println("shutting down")
+	}
+
+	cancel()
+	listening.Wait()
+	shutdown()
+	return err
 }
 
 // HTTPServer implements Server interface for regular HTTP/HTTPS
 type HTTPServer struct {
-	Port     string
-	CertFile string
-	KeyFile  string
+	// Addresses are the IP addresses or hostnames to listen on. Empty
+	// means every interface.
+	Addresses []string
+	Port      string
+	CertFile  string
+	KeyFile   string
+	// ACME gets certificates from an ACME server instead of CertFile and
+	// KeyFile
+	ACME ACMEConfig
+
+	acme        *autocert.Manager
+	challengeLn net.Listener
+	challenge   httpServerHolder
+	httpServerHolder
+}
+
+func (s *HTTPServer) Listen(context.Context) (net.Listener, error) {
+	if s.ACME.Enabled {
+		if err := s.ACME.Validate(); err != nil {
+			return nil, err
+		}
+		manager, err := newACMEManager(s.ACME)
+		if err != nil {
+			return nil, err
+		}
+		s.acme = manager
+	}
+
+	ln, err := listenTCP(s.Addresses, s.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.acme != nil && s.ACME.usesHTTPChallenge() {
+		s.challengeLn, err = listenTCP(s.Addresses, s.ACME.httpPort())
+		if err != nil {
+			_ = ln.Close()
+			return nil, fmt.Errorf("could not listen for http-01 challenges: %w", err)
+		}
+	}
+	return ln, nil
 }
 
-func (s *HTTPServer) Listen() (net.Listener, error) {
-	return net.Listen("tcp", ":"+s.Port)
+// listenTCP listens on the port on each address, or on every interface when
+// there are no addresses
+func listenTCP(addresses []string, port string) (net.Listener, error) {
+	if len(addresses) == 0 {
+		return net.Listen("tcp", ":"+port)
+	}
+
+	var listeners []net.Listener
+	for _, addr := range addresses {
+		// IPv6 addresses may come with brackets, as they would in a URL
+		ln, err := net.Listen("tcp", net.JoinHostPort(strings.Trim(addr, "[]"), port))
+		if err != nil {
+			for _, ln := range listeners {
+				_ = ln.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	if len(listeners) == 1 {
+		return listeners[0], nil
+	}
+	return newMultiListener(listeners), nil
 }
 
-func (s *HTTPServer) Serve(ln net.Listener) error {
-	if s.CertFile == "" && s.KeyFile == "" {
-		log.Println("starting http on port", s.Port)
-		return http.Serve(ln, nil)
-	} else {
-		log.Println("starting https on port", s.Port)
-		tlsConfig := &tls.Config{}
-		tlsListener := tls.NewListener(ln, tlsConfig)
-		return http.ServeTLS(tlsListener, nil, s.CertFile, s.KeyFile)
+func (s *HTTPServer) Serve(ln net.Listener, handler http.Handler) error {
+	srv := s.httpServer()
+	srv.Handler = handler
+
+	switch {
+	case s.acme != nil:
+		srv.TLSConfig = s.acme.TLSConfig()
+		if s.challengeLn != nil {
+			// Everything but challenges is redirected to HTTPS
+			challengeSrv := s.challenge.httpServer()
+			challengeSrv.Handler = s.acme.HTTPHandler(nil)
+			go func() {
+				if err := challengeSrv.Serve(s.challengeLn); err != nil && err != http.ErrServerClosed {
+					log.Printf("Error serving http-01 challenges: %v", err)
+				}
+			}()
+		}
+
+		log.Printf("starting https on %s with certificates for %s from %s", s.where(), strings.Join(s.ACME.Domains, ", "), s.acme.Client.DirectoryURL)
+		return srv.ServeTLS(ln, "", "")
+
+	case s.CertFile != "" || s.KeyFile != "":
+		// Certificates are reloaded when their files change, so renewing
+		// them doesn't need a restart
+		reloader, err := NewCertReloader(s.CertFile, s.KeyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+
+		log.Println("starting https on", s.where())
+		return srv.ServeTLS(ln, "", "")
+
+	default:
+		log.Println("starting http on", s.where())
+		return srv.Serve(ln)
 	}
 }
 
+// where describes the addresses and port for logs
+func (s *HTTPServer) where() string {
+	if len(s.Addresses) == 0 {
+		return "port " + s.Port
+	}
+	return strings.Join(s.Addresses, ", ") + " port " + s.Port
+}
+
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	err := s.httpServer().Shutdown(ctx)
+	if s.challengeLn != nil {
+		_ = s.challenge.httpServer().Shutdown(ctx)
+		_ = s.challengeLn.Close()
+	}
+	return err
+}
+
+// UnixServer implements Server interface for a Unix domain socket, for
+// tools and reverse proxies on the same host
+type UnixServer struct {
+	Path string
+	// Mode sets the socket's permissions, which decide who can connect.
+	// Zero means only the user netclip runs as.
+	Mode os.FileMode
+
+	httpServerHolder
+}
+
+func (s *UnixServer) Listen(ctx context.Context) (net.Listener, error) {
+	// A socket left behind by a crash would stop us listening, but one that
+	// answers belongs to a server that's still running
+	if info, err := os.Lstat(s.Path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", s.Path)
+		}
+		dialer := &net.Dialer{Timeout: time.Second}
+		if conn, err := dialer.DialContext(ctx, "unix", s.Path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", s.Path)
+		}
+		if err := os.Remove(s.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	// The socket is made in a directory only we can open and moved into
+	// place once it has its permissions, so nobody can connect in between
+	dir, err := os.MkdirTemp(filepath.Dir(s.Path), ".netclip-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	mode := s.Mode
+	if mode == 0 {
+		mode = 0600
+	}
+	if err := os.Chmod(private, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(private, s.Path); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return &socketListener{Listener: ln, path: s.Path}, nil
+}
+
+// socketListener removes the socket when it closes. The listener itself
+// only knows the private path the socket was made at.
+type socketListener struct {
+	net.Listener
+	path string
+	once sync.Once
+}
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { _ = os.Remove(l.path) })
+	return err
+}
+
+func (s *UnixServer) Serve(ln net.Listener, handler http.Handler) error {
+	log.Println("starting http on socket", s.Path)
+	srv := s.httpServer()
+	srv.Handler = unixPeer(handler)
+	return srv.Serve(ln)
+}
+
+func (s *UnixServer) Shutdown(ctx context.Context) error {
+	return s.httpServer().Shutdown(ctx)
+}
+
+// unixPeer gives requests from a Unix socket a loopback address. Socket
+// peers have no IP address, but they're on this host and got past the
+// socket's permissions, so the read and write rules treat them like
+// localhost. They're marked as socket peers too, because a proxy in front of
+// the socket makes everyone look local, so they only get the admin pages
+// when the admin rules let 127.0.0.1 in.
+func unixPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "127.0.0.1:0"
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), socketPeerKey{}, true)))
+	})
+}
+
 // TSNetServer implements Server interface for Tailscale networking
 type TSNetServer struct {
 	Hostname string
 	AuthKey  string
 	UseTLS   bool
+	// Dir keeps the node's state, chosen by tsnet when empty
+	Dir       string
+	Ephemeral bool
+	// Tags the node advertises when it registers, like tag:netclip.
+	// Listen fails if the tailnet doesn't give it exactly these.
+	Tags []string
+	// ControlURL is the coordination server, Tailscale's when empty
+	ControlURL string
+
+	ts *tsnet.Server
+	lc *local.Client
+	httpServerHolder
 }
 
-func (s *TSNetServer) Listen() (net.Listener, error) {
+// newTSNetServer creates a server from the tailscale settings
+func newTSNetServer(config TailscaleConfig, authKey string) *TSNetServer {
+	hostname := config.Hostname
+	if hostname == "" {
+		hostname = "netclip"
+	}
+	return &TSNetServer{
+		Hostname:   hostname,
+		AuthKey:    authKey,
+		UseTLS:     config.UseTLS,
+		Dir:        config.StateDir,
+		Ephemeral:  config.Ephemeral,
+		Tags:       config.Tags,
+		ControlURL: config.ControlURL,
+	}
+}
+
+func (s *TSNetServer) Listen(ctx context.Context) (net.Listener, error) {
 	srv := &tsnet.Server{
-		Hostname: s.Hostname,
+		Hostname:      s.Hostname,
+		Dir:           s.Dir,
+		Ephemeral:     s.Ephemeral,
+		ControlURL:    s.ControlURL,
+		AdvertiseTags: s.Tags,
 	}
+	s.ts = srv
 
 	// Only set AuthKey if provided, otherwise TSNet will prompt for manual auth
 	if s.AuthKey != "" {
 		srv.AuthKey = s.AuthKey
 	}
 
+	if len(s.Tags) > 0 {
+		if err := s.checkTags(ctx); err != nil {
+			srv.Close()
+			return nil, err
+		}
+	}
+
 	addr := ":80"
 	if s.UseTLS {
 		addr = ":443"
@@ -102,67 +701,118 @@ func (s *TSNetServer) Listen() (net.Listener, error) {
 		return nil, err
 	}
 
+	s.lc, err = srv.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+
 	if s.UseTLS {
-		lc, err := srv.LocalClient()
-		if err != nil {
-			return nil, err
-		}
 		ln = tls.NewListener(ln, &tls.Config{
-			GetCertificate: lc.GetCertificate,
+			GetCertificate: s.lc.GetCertificate,
 		})
 	}
 
 	return ln, nil
 }
 
-func (s *TSNetServer) Serve(ln net.Listener) error {
+// checkTags waits for the node to join the tailnet and makes sure it got
+// the tags it advertised. The tailnet only grants them if the auth key or
+// its owner is allowed to use them, and a node that's already registered
+// keeps its old tags until it registers again.
+func (s *TSNetServer) checkTags(ctx context.Context) error {
+	status, err := s.ts.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	var tags []string
+	if status.Self != nil && status.Self.Tags != nil {
+		tags = status.Self.Tags.AsSlice()
+	}
+	if !sameTags(tags, s.Tags) {
+		have := "no tags"
+		if len(tags) > 0 {
+			have = "tags " + strings.Join(tags, ", ")
+		}
+		return fmt.Errorf("tailscale node has %s but tailscale.tags is %s. Allow the auth key or its owner to use those tags, and remove the node's state in %s so it registers again",
+			have, strings.Join(s.Tags, ", "), s.stateDir())
+	}
+	return nil
+}
+
+// stateDir describes where the node keeps its state, for error messages
+func (s *TSNetServer) stateDir() string {
+	if s.Dir != "" {
+		return s.Dir
+	}
+	return "the user config directory"
+}
+
+func sameTags(a, b []string) bool {
+	return slices.Equal(slices.Compact(slices.Sorted(slices.Values(a))), slices.Compact(slices.Sorted(slices.Values(b))))
+}
+
+func (s *TSNetServer) Serve(ln net.Listener, handler http.Handler) error {
 	if s.UseTLS {
 		log.Printf("starting TSNet HTTPS server as %s", s.Hostname)
 	} else {
 		log.Printf("starting TSNet HTTP server as %s", s.Hostname)
 	}
-	return http.Serve(ln, nil)
+	srv := s.httpServer()
+	srv.Handler = tailnetIdentity(s.lc, handler)
+	return srv.Serve(ln)
 }
 
-// Run starts the server using the provided Server implementation
-func Run(server Server) {
-	setupHandlers()
+// Shutdown drains in-flight requests and then takes the node off the tailnet
+func (s *TSNetServer) Shutdown(ctx context.Context) error {
+	err := s.httpServer().Shutdown(ctx)
+	if s.ts != nil {
+		if closeErr := s.ts.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
 
-	ln, err := server.Listen()
+// Run creates an app from the config and serves it until ctx is cancelled
+// or the server fails, then saves clips and closes the audit log.
+func Run(ctx context.Context, server Server, config Config) error {
+	app, err := NewApp(config)
 	if err != nil {
-		log.Fatal("Could not create listener: ", err)
+		return fmt.Errorf("could not set up app: %w", err)
 	}
 
-	err = server.Serve(ln)
-	if err != nil {
-		log.Fatal("Could not start server: ", err)
+	err = app.Run(ctx, server)
+	if closeErr := app.Close(); closeErr != nil {
+		log.Printf("Error closing app: %v", closeErr)
 	}
+	return err
 }
 
 // IndexHandler shows the page that displays the form and the results
-func IndexHandler(w http.ResponseWriter, _ *http.Request) {
+func (a *App) IndexHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html")
 
+	sessionID := ensureSession(w, r)
+
 	templateData := struct {
 		AppVersion string
+		CACert     bool
+		CSRFToken  string
 		DataStore  *DataStore
+		Mode       Mode
 		Year       int
 	}{
 		AppVersion: AppVersion,
-		DataStore:  &dataStore,
+		CACert:     a.autoCerts != nil,
+		CSRFToken:  csrfToken(a.csrfSecret, sessionID),
+		DataStore:  &a.clips,
+		Mode:       requestMode(r, a.currentMode()),
 		Year:       time.Now().Year(),
 	}
 
-	indexTemplate, err := template.ParseFS(staticFiles, "static/index.html")
-
-	if err != nil {
-		log.Printf("Error parsing index template: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	err = indexTemplate.Execute(w, templateData)
+	err := a.templates.ExecuteTemplate(w, "index.html", templateData)
 	if err != nil {
 		log.Printf("Error executing template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -195,7 +845,11 @@ func StaticFileHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // SaveHandler saves records to the DataStore
-func SaveHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) SaveHandler(w http.ResponseWriter, r *http.Request) {
+	if !modeAllows(w, requestMode(r, a.currentMode()), Mode.CanSave) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 
 	err := r.ParseForm()
@@ -205,6 +859,12 @@ func SaveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := verifyCSRF(r, a.csrfSecret); err != nil {
+		log.Printf("Rejected save: %v", err)
+		http.Error(w, "Forbidden: "+err.Error()+", reload the page and try again", http.StatusForbidden)
+		return
+	}
+
 	textToSave := r.PostForm.Get("text")
 
 	if textToSave == "" {
@@ -214,14 +874,116 @@ func SaveHandler(w http.ResponseWriter, r *http.Request) {
 		// Use a unique key for each saved text
 	}
 
-	key := fmt.Sprintf("%d", time.Now().UnixNano())
-	dataStore.Store(key, textToSave)
+	// app.js encrypts the text before posting when end-to-end encryption is
+	// checked. If the box came through with plain text, the script didn't run
+	// and we mustn't store what the user wanted kept from us.
+	if r.PostForm.Get("e2e") != "" && !IsE2E(textToSave) {
+		_, _ = fmt.Fprint(w, "<h1>End-to-end encryption needs JavaScript</h1>")
+		return
+	}
+	if err := checkE2E(textToSave); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "<h1>Malformed encrypted clip</h1>")
+		return
+	}
+
+	a.saveClip(r, textToSave, r.PostForm.Get("passphrase"))
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// APIClipsHandler saves a clip sent as JSON and responds with its key and
+// link. It's used by the command line client and by app.js for end-to-end
+// encrypted clips. Browsers can't send JSON to another site without a CORS
+// preflight, which we never grant, so the Origin check is all the cross-site
+// protection it needs.
+func (a *App) APIClipsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !modeAllows(w, requestMode(r, a.currentMode()), Mode.CanSave) {
+		return
+	}
+
+	if err := checkOrigin(r); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var clip apiClip
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 10<<20)).Decode(&clip); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if clip.Text == "" {
+		http.Error(w, "Text is blank", http.StatusBadRequest)
+		return
+	}
+	if err := checkE2E(clip.Text); err != nil {
+		http.Error(w, "Malformed encrypted clip", http.StatusBadRequest)
+		return
+	}
+
+	key, saved := a.saveClip(r, clip.Text, clip.Passphrase)
+
+	created := apiClip{
+		Key:       key,
+		Secrets:   saved.Secrets,
+		ExpiresAt: saved.ExpiresAt,
+	}
+	// There's no link to a clip nobody can view
+	if requestMode(r, a.currentMode()).CanRead() {
+		created.URL = "/clip/" + key
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+// saveClip stores text as a new clip, protected by the passphrase if there
+// is one. Clips that look like they contain secrets are flagged and may be
+// given a short life.
+func (a *App) saveClip(r *http.Request, text, passphrase string) (string, Clip) {
+	clip := Clip{Text: text}
+	if passphrase != "" {
+		clip.PasswordHash = HashPassphrase(passphrase)
+	}
+
+	// There's nothing to see in end-to-end encrypted clips
+	secretScan := a.settings().SecretScan
+	if !secretScan.Disabled && !IsE2E(text) {
+		clip.Secrets = ScanSecrets(text)
+		if clip.Flagged() && secretScan.FlaggedTTL > 0 {
+			clip.ExpiresAt = time.Now().Add(secretScan.FlaggedTTL)
+		}
+	}
+
+	key := fmt.Sprintf("%d", time.Now().UnixNano())
+	a.clips.StoreClip(key, clip)
+	a.recordAudit(r, AuditCreate, key)
+
+	if clip.Flagged() {
+		log.Printf("Clip %s looks like it contains: %s", key, strings.Join(clip.Secrets, ", "))
+	}
+	return key, clip
+}
+
 // DeleteHandler deletes records from the DataStore
-func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !modeAllows(w, requestMode(r, a.currentMode()), Mode.CanDelete) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 
 	err := r.ParseForm()
@@ -230,6 +992,12 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := verifyCSRF(r, a.csrfSecret); err != nil {
+		log.Printf("Rejected delete: %v", err)
+		http.Error(w, "Forbidden: "+err.Error()+", reload the page and try again", http.StatusForbidden)
+		return
+	}
+
 	keyToDelete := r.PostForm.Get("key")
 
 	if keyToDelete == "" {
@@ -237,7 +1005,131 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dataStore.Delete(keyToDelete)
+	a.clips.Delete(keyToDelete)
+	a.recordAudit(r, AuditDelete, keyToDelete)
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// ClipHandler shows a single clip on its own page. End-to-end encrypted clips
+// are decrypted in the browser with the key from the link's fragment.
+func (a *App) ClipHandler(w http.ResponseWriter, r *http.Request) {
+	if !modeAllows(w, requestMode(r, a.currentMode()), Mode.CanRead) {
+		return
+	}
+
+	key := r.PathValue("key")
+
+	clip, ok := a.clips.GetClip(key)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !a.unlockClip(w, r, key, clip) {
+		return
+	}
+	a.recordAudit(r, AuditView, key)
+
+	w.Header().Set("Content-Type", "text/html")
+
+	templateData := struct {
+		AppVersion string
+		Key        string
+		Value      string
+		Year       int
+	}{
+		AppVersion: AppVersion,
+		Key:        key,
+		Value:      clip.Text,
+		Year:       time.Now().Year(),
+	}
+
+	err := a.templates.ExecuteTemplate(w, "clip.html", templateData)
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// RawHandler returns a single clip as plain text
+func (a *App) RawHandler(w http.ResponseWriter, r *http.Request) {
+	if !modeAllows(w, requestMode(r, a.currentMode()), Mode.CanRead) {
+		return
+	}
+
+	key := r.PathValue("key")
+
+	clip, ok := a.clips.GetClip(key)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !a.unlockClip(w, r, key, clip) {
+		return
+	}
+	a.recordAudit(r, AuditView, key)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprint(w, clip.Text)
+}
+
+// CACertHandler downloads the certificate authority made for auto TLS, so
+// devices can be set to trust it
+func (a *App) CACertHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="netclip-ca.crt"`)
+	_, _ = w.Write(a.autoCerts.CACert)
+}
+
+// AuditHandler lists audit log entries as JSON, filtered by the action, key,
+// client, since and limit query parameters
+func (a *App) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := AuditFilter{
+		Action: query.Get("action"),
+		Key:    query.Get("key"),
+		Client: query.Get("client"),
+		Limit:  100,
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := a.auditLog.Query(filter)
+	if err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+// recordAudit adds a clip operation by the requesting client to the audit log
+func (a *App) recordAudit(r *http.Request, action, key string) {
+	err := a.auditLog.Record(AuditEntry{
+		Action: action,
+		Key:    key,
+		Client: clientIdentity(r),
+	})
+	if err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package netclip
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditConfig controls where clip operations are recorded
+type AuditConfig struct {
+	File string `yaml:"file"`
+}
+
+// Audit actions
+const (
+	AuditCreate = "create"
+	AuditView   = "view"
+	AuditDelete = "delete"
+)
+
+// maxMemoryAuditEntries caps the audit log when it isn't backed by a file
+const maxMemoryAuditEntries = 1000
+
+// AuditEntry is a single recorded clip operation
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Key    string    `json:"key"`
+	Client string    `json:"client"`
+}
+
+// AuditFilter narrows down a query of the audit log. Empty fields match everything.
+type AuditFilter struct {
+	Action string
+	Key    string
+	Client string
+	Since  time.Time
+	Limit  int
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Key != "" && entry.Key != f.Key {
+		return false
+	}
+	if f.Client != "" && !strings.Contains(entry.Client, f.Client) {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+// AuditLog is an append-only record of clip operations. It writes JSON lines
+// to a file, or keeps the most recent entries in memory if there's no file.
+type AuditLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries []AuditEntry
+}
+
+// OpenAuditLog opens the audit log at the given path, creating it if needed.
+// An empty path gives an in-memory log.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		return &AuditLog{}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: path, file: file}, nil
+}
+
+// Record appends an entry to the log, stamping it with the current time if it has none
+func (al *AuditLog) Record(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.file == nil {
+		al.entries = append(al.entries, entry)
+		if len(al.entries) > maxMemoryAuditEntries {
+			al.entries = al.entries[len(al.entries)-maxMemoryAuditEntries:]
+		}
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = al.file.Write(append(line, '\n'))
+	return err
+}
+
+// Query returns the entries matching the filter, newest first
+func (al *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	entries := al.entries
+	if al.file != nil {
+		var err error
+		entries, err = readAuditFile(al.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := []AuditEntry{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !filter.matches(entries[i]) {
+			continue
+		}
+		results = append(results, entries[i])
+		if filter.Limit > 0 && len(results) == filter.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// Close closes the log file
+func (al *AuditLog) Close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.file == nil {
+		return nil
+	}
+	err := al.file.Close()
+	al.file = nil
+	return err
+}
+
+func readAuditFile(path string) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		// Skip lines that didn't make it to disk intact
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,76 @@
+package netclip_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogFileIsAppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	auditLog, err := netclip.OpenAuditLog(path)
+	assert.NoError(t, err)
+	assert.NoError(t, auditLog.Record(netclip.AuditEntry{Action: netclip.AuditCreate, Key: "1", Client: "192.168.1.20"}))
+	assert.NoError(t, auditLog.Close())
+
+	// Reopening keeps earlier entries
+	auditLog, err = netclip.OpenAuditLog(path)
+	assert.NoError(t, err)
+	defer auditLog.Close()
+	assert.NoError(t, auditLog.Record(netclip.AuditEntry{Action: netclip.AuditDelete, Key: "1", Client: "alice@example.com"}))
+
+	entries, err := auditLog.Query(netclip.AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// Newest first
+	assert.Equal(t, netclip.AuditDelete, entries[0].Action)
+	assert.Equal(t, "alice@example.com", entries[0].Client)
+	assert.False(t, entries[0].Time.IsZero())
+	assert.Equal(t, netclip.AuditCreate, entries[1].Action)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	if os.PathSeparator == '/' {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestAuditLogQueryFilters(t *testing.T) {
+	auditLog, err := netclip.OpenAuditLog("")
+	assert.NoError(t, err)
+
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, auditLog.Record(netclip.AuditEntry{Time: start, Action: netclip.AuditCreate, Key: "1", Client: "192.168.1.20"}))
+	assert.NoError(t, auditLog.Record(netclip.AuditEntry{Time: start.Add(time.Hour), Action: netclip.AuditView, Key: "1", Client: "alice@example.com"}))
+	assert.NoError(t, auditLog.Record(netclip.AuditEntry{Time: start.Add(2 * time.Hour), Action: netclip.AuditDelete, Key: "1", Client: "alice@example.com"}))
+	assert.NoError(t, auditLog.Record(netclip.AuditEntry{Time: start.Add(3 * time.Hour), Action: netclip.AuditCreate, Key: "2", Client: "192.168.1.21"}))
+
+	entries, err := auditLog.Query(netclip.AuditFilter{Action: netclip.AuditDelete})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "alice@example.com", entries[0].Client)
+
+	entries, err = auditLog.Query(netclip.AuditFilter{Key: "1"})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	entries, err = auditLog.Query(netclip.AuditFilter{Client: "192.168.1."})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	entries, err = auditLog.Query(netclip.AuditFilter{Since: start.Add(90 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	entries, err = auditLog.Query(netclip.AuditFilter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "2", entries[0].Key)
+}
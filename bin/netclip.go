@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"netclip"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kardianos/service"
 )
@@ -18,59 +26,402 @@ func banner() {
 var logger service.Logger
 
 type program struct {
-	Port          string
-	CertFile      string
-	KeyFile       string
-	TailscaleEnabled  bool
-	TailscaleHostname string
-	TailscaleAuthKey  string
-	TailscaleUseTLS   bool
+	Config           netclip.Config
+	TailscaleAuthKey string
+	// ConfigPaths are the config files to reload when they change or on SIGHUP
+	ConfigPaths []string
+	// Overrides applies the environment and command line flags again after
+	// a reload
+	Overrides func(netclip.Config) (netclip.Config, error)
+
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 func (p *program) Start(s service.Service) error {
 	// Start should not block. Do the actual work async.
-	go p.run()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.run(ctx)
 	return nil
 }
 
-func (p *program) run() {
-	if p.TailscaleEnabled {
-		server := &netclip.TSNetServer{
-			Hostname: p.TailscaleHostname,
-			AuthKey:  p.TailscaleAuthKey,
-			UseTLS:   p.TailscaleUseTLS,
-		}
-		netclip.Run(server)
-	} else {
-		server := &netclip.HTTPServer{
-			Port:     p.Port,
-			CertFile: p.CertFile,
-			KeyFile:  p.KeyFile,
-		}
-		netclip.Run(server)
+func (p *program) run(ctx context.Context) {
+	defer close(p.done)
+
+	app, err := netclip.NewApp(p.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(p.ConfigPaths) > 0 {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		go app.WatchConfig(ctx, p.ConfigPaths, hup, p.loadConfig)
+	}
+
+	err = app.RunListeners(ctx, p.TailscaleAuthKey)
+	if closeErr := app.Close(); closeErr != nil {
+		log.Printf("Error closing app: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
+// loadConfig reads the config files again with the environment and flags on top
+func (p *program) loadConfig() (netclip.Config, error) {
+	config, err := netclip.LoadConfigs(p.ConfigPaths...)
+	if err != nil {
+		return netclip.Config{}, err
+	}
+	return p.Overrides(config)
+}
+
 func (p *program) Stop(s service.Service) error {
 	// Stop should not block. Return with a few seconds.
+	p.cancel()
+
+	select {
+	case <-p.done:
+	case <-time.After(netclip.ShutdownTimeout + time.Second):
+		log.Println("Timed out waiting for netclip to shut down")
+	}
 	return nil
 }
 
 
+// defaultServerURL is where the client commands send requests unless told otherwise
+func defaultServerURL() string {
+	if url := os.Getenv("NETCLIP_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:9999"
+}
+
+// pasteCommand saves a file, or standard input, as a clip and prints its link
+func pasteCommand(args []string) {
+	flags := flag.NewFlagSet("paste", flag.ExitOnError)
+	server := flags.String("server", defaultServerURL(), "netclip server URL (default: $NETCLIP_URL or http://localhost:9999)")
+	e2e := flags.Bool("e2e", false, "Encrypt the clip end-to-end. Only the printed link can decrypt it.")
+	passphrase := flags.String("passphrase", os.Getenv("NETCLIP_PASSPHRASE"), "Passphrase needed to view the clip (default: $NETCLIP_PASSPHRASE)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: netclip paste [-server url] [-e2e] [-passphrase phrase] [file]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	var data []byte
+	var err error
+	if flags.NArg() > 0 {
+		data, err = os.ReadFile(flags.Arg(0))
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := &netclip.Client{BaseURL: *server, Passphrase: *passphrase}
+	link, err := client.Paste(string(data), *e2e)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if link == "" {
+		fmt.Fprintln(os.Stderr, "Saved. The server is write-only, so the clip can't be viewed.")
+		return
+	}
+	fmt.Println(link)
+}
+
+// getCommand prints the clip at a link, decrypting it if the link has a key
+func getCommand(args []string) {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	passphrase := flags.String("passphrase", os.Getenv("NETCLIP_PASSPHRASE"), "Passphrase for a protected clip (default: $NETCLIP_PASSPHRASE)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: netclip get [-passphrase phrase] <link>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	client := &netclip.Client{Passphrase: *passphrase}
+	text, err := client.Get(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(text)
+}
+
+// serverFlags are the command line flags that override config settings
+type serverFlags struct {
+	flags              *flag.FlagSet
+	port               *string
+	listen             *string
+	cert               *string
+	key                *string
+	tailscale          *bool
+	tailscaleHostname  *string
+	tailscaleTLS       *bool
+	tailscaleStateDir  *string
+	tailscaleEphemeral *bool
+	tailscaleTags      *string
+	tailscaleControl   *string
+}
+
+// flagSettings maps each server flag to the setting it overrides
+var flagSettings = map[string]string{
+	"port":                  "port",
+	"listen":                "addresses",
+	"cert":                  "cert_file",
+	"key":                   "key_file",
+	"tailscale":             "tailscale.enabled",
+	"tailscale-hostname":    "tailscale.hostname",
+	"tailscale-tls":         "tailscale.use_tls",
+	"tailscale-state-dir":   "tailscale.state_dir",
+	"tailscale-ephemeral":   "tailscale.ephemeral",
+	"tailscale-tags":        "tailscale.tags",
+	"tailscale-control-url": "tailscale.control_url",
+}
+
+func addServerFlags(flags *flag.FlagSet) *serverFlags {
+	return &serverFlags{
+		flags:              flags,
+		port:               flags.String("port", "", "Port to use (default: 9999)"),
+		listen:             flags.String("listen", "", "Comma-separated IP addresses or host names to listen on, like 127.0.0.1,::1 (default: all interfaces)"),
+		cert:               flags.String("cert", "", "Path to SSL certificate file"),
+		key:                flags.String("key", "", "Path to SSL private key file"),
+		tailscale:          flags.Bool("tailscale", false, "Enable Tailscale networking"),
+		tailscaleHostname:  flags.String("tailscale-hostname", "", "Tailscale hostname (default: netclip)"),
+		tailscaleTLS:       flags.Bool("tailscale-tls", false, "Use HTTPS with Tailscale certificates"),
+		tailscaleStateDir:  flags.String("tailscale-state-dir", "", "Directory for the Tailscale node's state (default: in the user config directory)"),
+		tailscaleEphemeral: flags.Bool("tailscale-ephemeral", false, "Remove the node from the tailnet when it goes offline"),
+		tailscaleTags:      flags.String("tailscale-tags", "", "Comma-separated tags the node advertises, like tag:netclip"),
+		tailscaleControl:   flags.String("tailscale-control-url", "", "Coordination server URL, such as a Headscale server (default: Tailscale's)"),
+	}
+}
+
+// overrides applies NETCLIP_* environment variables and then the flags on
+// top of the config file, so flags win over the environment
+func (f *serverFlags) overrides(config netclip.Config) (netclip.Config, error) {
+	config, err := netclip.ApplyEnv(config)
+	if err != nil {
+		return netclip.Config{}, err
+	}
+	return netclip.ApplyFlags(config, netclip.Flags{
+		Port:                *f.port,
+		Addresses:           *f.listen,
+		CertFile:            *f.cert,
+		KeyFile:             *f.key,
+		Tailscale:           *f.tailscale,
+		TailscaleHostname:   *f.tailscaleHostname,
+		TailscaleTLS:        *f.tailscaleTLS,
+		TailscaleStateDir:   *f.tailscaleStateDir,
+		TailscaleEphemeral:  *f.tailscaleEphemeral,
+		TailscaleTags:       *f.tailscaleTags,
+		TailscaleControlURL: *f.tailscaleControl,
+	}), nil
+}
+
+// settings lists the settings given by flags on the command line
+func (f *serverFlags) settings() []string {
+	var settings []string
+	f.flags.Visit(func(fl *flag.Flag) {
+		if setting, ok := flagSettings[fl.Name]; ok {
+			settings = append(settings, setting)
+		}
+	})
+	return settings
+}
+
+// configCommand runs the config subcommands
+func configCommand(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "check":
+			checkConfigCommand(args[1:])
+			return
+		case "show":
+			showConfigCommand(args[1:])
+			return
+		case "init":
+			initConfigCommand(args[1:])
+			return
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: netclip config check|show [file...]")
+	fmt.Fprintln(os.Stderr, "       netclip config init [-force] [file]")
+	os.Exit(2)
+}
+
+// initConfigCommand writes a commented config file to start from
+func initConfigCommand(args []string) {
+	flags := flag.NewFlagSet("config init", flag.ExitOnError)
+	force := flags.Bool("force", false, "Replace the file if it already exists")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: netclip config init [-force] [file]")
+		fmt.Fprintln(flags.Output(), "Writes a commented config file with the defaults, netclip.yml in this directory by default.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	path := flags.Arg(0)
+	if path == "" {
+		path = "netclip.yml"
+	}
+	if err := netclip.InitConfig(path, *force); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+}
+
+// showConfigCommand prints the config netclip would run with, after the
+// environment and flags, and where each setting came from
+func showConfigCommand(args []string) {
+	flags := flag.NewFlagSet("config show", flag.ExitOnError)
+	server := addServerFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: netclip config show [flags] [file...]")
+		fmt.Fprintln(flags.Output(), "Prints the config netclip would run with. Takes the same flags as the server.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = netclip.FindConfigPaths()
+	}
+	config, err := netclip.LoadConfigs(paths...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config, err = server.overrides(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sources, err := netclip.SettingSources(paths, server.settings())
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := netclip.MarshalConfig(config, sources)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(paths) > 0 {
+		fmt.Printf("# Loaded from %s\n", strings.Join(paths, ", "))
+	} else {
+		fmt.Println("# No config file found")
+	}
+	os.Stdout.Write(out)
+}
+
+// checkConfigCommand prints every problem with a config file and exits
+// non-zero if there are any
+func checkConfigCommand(args []string) {
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: netclip config check [file...]")
+		fmt.Fprintln(flags.Output(), "Checks the files, or the ones netclip would load, and the NETCLIP_* environment variables.")
+	}
+	_ = flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = netclip.FindConfigPaths()
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "No config file found. Searched:\n  %s\n", strings.Join(netclip.GetConfigPaths(), "\n  "))
+			os.Exit(1)
+		}
+	}
+
+	config, errs := netclip.CheckConfigFiles(paths...)
+	if len(errs) == 0 {
+		if _, err := netclip.ApplyEnv(config); err != nil {
+			errs = append(errs, &netclip.ConfigError{Err: err})
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: valid\n", strings.Join(paths, ", "))
+}
+
+// loadServerConfig loads the config files at paths, or the ones found in
+// the standard locations, applies the environment and flags, and checks
+// every setting. It exits if anything is wrong.
+func loadServerConfig(paths []string, server *serverFlags) (netclip.Config, []string) {
+	var config netclip.Config
+	var err error
+	if len(paths) > 0 {
+		config, err = netclip.LoadConfigs(paths...)
+		if err != nil {
+			log.Fatalf("%v\nRun 'netclip config check %s' to see every problem.", err, paths[0])
+		}
+	} else {
+		// Try loading config from multiple standard locations
+		config, paths, err = netclip.FindConfig()
+		if errors.Is(err, netclip.ErrNoConfig) {
+			log.Printf("No config file found")
+			log.Printf("Searched paths: %v", netclip.GetConfigPaths())
+			log.Printf("Using default options.")
+		} else if err != nil {
+			log.Fatalf("%v\nRun 'netclip config check' to see every problem.", err)
+		}
+	}
+
+	// Flags take precedence over NETCLIP_* environment variables, which take
+	// precedence over the config file
+	config, err = server.overrides(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if errs := netclip.CheckConfig(config); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		log.Fatal("netclip can't start until these settings are fixed.")
+	}
+	return config, paths
+}
+
 func main() {
 	var serviceMode string
 
+	// Client commands have their own flags, so handle them before parsing ours
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "paste":
+			pasteCommand(os.Args[2:])
+			return
+		case "get":
+			getCommand(os.Args[2:])
+			return
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		}
+	}
+
 	version := flag.Bool("v", false, "Prints current app version.")
 	flag.StringVar(&serviceMode, "service", "", "install/restart/start/stop/uninstall")
 
-	// Define flags with pointers to detect if they were set
-	portFlag := flag.String("port", "", "Port to use (default: 9999)")
-	certFlag := flag.String("cert", "", "Path to SSL certificate file")
-	keyFlag := flag.String("key", "", "Path to SSL private key file")
-	tailscaleFlag := flag.Bool("tailscale", false, "Enable Tailscale networking")
-	tailscaleHostnameFlag := flag.String("tailscale-hostname", "", "Tailscale hostname (default: netclip)")
-	tailscaleTLSFlag := flag.Bool("tailscale-tls", false, "Use HTTPS with Tailscale certificates")
+	server := addServerFlags(flag.CommandLine)
 	serviceUserFlag := flag.String("service-user", "", "User to run service as (required for install on Linux/macOS)")
+	configFlag := flag.String("config", "", "Config file to use instead of searching the standard locations")
 
 	flag.Parse()
 
@@ -79,17 +430,33 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Try loading config from multiple standard locations
-	config, err := netclip.LoadConfigFromPaths()
-	
-	if err != nil {
-		log.Printf("Failed to load config from any location: %v", err)
-		log.Printf("Searched paths: %v", netclip.GetConfigPaths())
-		log.Printf("Using default options.")
+	var configPaths []string
+	if *configFlag != "" {
+		// The service runs from another directory, so it needs the full path
+		configFile, err := filepath.Abs(*configFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configPaths = []string{configFile}
 	}
 
-	// Apply flag overrides - flags take precedence over config file
-	config = netclip.ApplyFlags(config, *portFlag, *certFlag, *keyFlag, *tailscaleHostnameFlag, *tailscaleFlag, *tailscaleTLSFlag)
+	// Only the modes that run the server need the config, so a broken one
+	// doesn't stop the service being stopped or uninstalled
+	var config netclip.Config
+	var authKey string
+	if serviceMode == "" || serviceMode == "run" {
+		config, configPaths = loadServerConfig(configPaths, server)
+
+		// The key is only read when it's used, so a missing key file doesn't
+		// matter while tailscale is off
+		if config.UsesTailscale() {
+			var err error
+			authKey, err = config.Tailscale.AuthKey()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
 
 	// a mode was passed. Someone wants to do service things.
 
@@ -98,19 +465,21 @@ func main() {
 		DisplayName: "netclip Clipboard Server",
 		Description: "Tiny server for a text clipboard for your network",
 	}
-	
+
 	if *serviceUserFlag != "" {
 		svcConfig.UserName = *serviceUserFlag
 	}
 
+	// Installing the service saves the config file into its definition
+	if *configFlag != "" {
+		svcConfig.Arguments = []string{"-config", configPaths[0]}
+	}
+
 	prg := &program{
-		Port:          config.Port,
-		CertFile:      config.CertFile,
-		KeyFile:       config.KeyFile,
-		TailscaleEnabled:  config.Tailscale.Enabled,
-		TailscaleHostname: config.Tailscale.Hostname,
-		TailscaleAuthKey:  os.Getenv("TS_AUTHKEY"),
-		TailscaleUseTLS:   config.Tailscale.UseTLS,
+		Config:           config,
+		TailscaleAuthKey: authKey,
+		ConfigPaths:      configPaths,
+		Overrides:        server.overrides,
 	}
 
 	s, err := service.New(prg, svcConfig)
@@ -164,10 +533,17 @@ func main() {
 
 	default:
 		// just run as a standalone server
-		if config.Tailscale.Enabled {
+		if len(config.Listeners) > 0 {
+			for _, l := range config.Listeners {
+				fmt.Printf("Starting netclip on %s\n", l)
+			}
+		} else if config.Tailscale.Enabled {
 			fmt.Printf("Starting netclip on Tailscale as %s.ts.net\n", config.Tailscale.Hostname)
 		} else {
 			fmt.Printf("Starting netclip on port %s\n", config.Port)
+			for _, addr := range config.Addresses {
+				fmt.Printf("Listening on %s\n", addr)
+			}
 		}
 		if err = s.Run(); err != nil {
 			log.Fatal(err)
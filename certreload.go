@@ -0,0 +1,105 @@
+package netclip
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a certificate and key from disk and picks up new
+// files without a restart, so renewed certificates take effect on the next
+// connection
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	// Interval is how often the files are checked for changes
+	Interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	stamp     fileStamp
+	checkedAt time.Time
+}
+
+// fileStamp tells when the certificate or key files have been replaced
+type fileStamp struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// NewCertReloader loads a certificate and key. It fails if they can't be
+// loaded now; later failures keep the pair that's already loaded.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		Interval: 10 * time.Second,
+	}
+
+	stamp, err := cr.currentStamp()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cr.cert, cr.stamp, cr.checkedAt = &cert, stamp, time.Now()
+	return cr, nil
+}
+
+func (cr *CertReloader) currentStamp() (fileStamp, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{
+		certMod:  certInfo.ModTime(),
+		keyMod:   keyInfo.ModTime(),
+		certSize: certInfo.Size(),
+		keySize:  keyInfo.Size(),
+	}, nil
+}
+
+// GetCertificate returns the current certificate for tls.Config, reloading
+// it first if the files have changed
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.checkedAt) >= cr.Interval {
+		cr.checkedAt = time.Now()
+		cr.reload()
+	}
+	return cr.cert, nil
+}
+
+// reload loads the files if they've changed since the last load. A pair
+// that fails to load is logged and the old one kept. The files are tried
+// again on the next check, in case the new key hadn't been written yet.
+func (cr *CertReloader) reload() {
+	stamp, err := cr.currentStamp()
+	if err != nil {
+		log.Printf("Could not check certificate %s, keeping the current one: %v", cr.certFile, err)
+		return
+	}
+	if stamp == cr.stamp {
+		return
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		log.Printf("Could not load new certificate %s, keeping the current one: %v", cr.certFile, err)
+		return
+	}
+
+	log.Printf("Loaded new certificate %s", cr.certFile)
+	cr.cert, cr.stamp = &cert, stamp
+}
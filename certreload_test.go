@@ -0,0 +1,85 @@
+package netclip_test
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyPair copies a certificate and key over the files at certFile and
+// keyFile, dating them in the future so the change can't be missed
+func copyPair(t *testing.T, from *netclip.AutoCerts, certFile, keyFile string, at time.Time) {
+	for src, dst := range map[string]string{from.CertFile: certFile, from.KeyFile: keyFile} {
+		data, err := os.ReadFile(src)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(dst, data, 0600))
+		assert.NoError(t, os.Chtimes(dst, at, at))
+	}
+}
+
+func servedSerial(t *testing.T, cr *netclip.CertReloader) string {
+	cert, err := cr.GetCertificate(nil)
+	assert.NoError(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return parsed.SerialNumber.String()
+}
+
+func TestCertReloader(t *testing.T) {
+	first, err := netclip.EnsureAutoCerts(netclip.AutoTLSConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	second, err := netclip.EnsureAutoCerts(netclip.AutoTLSConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "netclip.crt"), filepath.Join(dir, "netclip.key")
+	copyPair(t, first, certFile, keyFile, time.Now())
+
+	cr, err := netclip.NewCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	cr.Interval = 0
+	original := servedSerial(t, cr)
+
+	// A renewed pair is picked up
+	copyPair(t, second, certFile, keyFile, time.Now().Add(time.Minute))
+	renewed := servedSerial(t, cr)
+	assert.NotEqual(t, original, renewed)
+
+	// A broken pair is ignored
+	assert.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0600))
+	assert.NoError(t, os.Chtimes(certFile, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute)))
+	assert.Equal(t, renewed, servedSerial(t, cr))
+
+	// Until it's fixed
+	copyPair(t, first, certFile, keyFile, time.Now().Add(3*time.Minute))
+	assert.Equal(t, original, servedSerial(t, cr))
+}
+
+func TestCertReloaderChecksOnInterval(t *testing.T) {
+	first, err := netclip.EnsureAutoCerts(netclip.AutoTLSConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	second, err := netclip.EnsureAutoCerts(netclip.AutoTLSConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "netclip.crt"), filepath.Join(dir, "netclip.key")
+	copyPair(t, first, certFile, keyFile, time.Now())
+
+	cr, err := netclip.NewCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	original := servedSerial(t, cr)
+
+	copyPair(t, second, certFile, keyFile, time.Now().Add(time.Minute))
+	assert.Equal(t, original, servedSerial(t, cr))
+}
+
+func TestNewCertReloaderNeedsAPair(t *testing.T) {
+	_, err := netclip.NewCertReloader(filepath.Join(t.TempDir(), "missing.crt"), filepath.Join(t.TempDir(), "missing.key"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,389 @@
+package netclip
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AutoTLSConfig has netclip make its own certificate authority and server
+// certificate, so browsers can use HTTPS without any openssl commands
+type AutoTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir holds the generated files. It defaults to a tls directory in the
+	// user's config directory.
+	Dir string `yaml:"dir"`
+	// Names are extra host names or IP addresses for the certificate, on
+	// top of the ones found on this host
+	Names []string `yaml:"names"`
+}
+
+// File names in the auto TLS directory
+const (
+	caCertFile     = "ca.crt"
+	caKeyFile      = "ca.key"
+	serverCertFile = "server.crt"
+	serverKeyFile  = "server.key"
+)
+
+const (
+	caLifetime     = 10 * 365 * 24 * time.Hour
+	serverLifetime = 397 * 24 * time.Hour
+	// renewBefore replaces the server certificate this long before it expires
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// AutoCertCheckInterval is how often a running app checks whether its
+// automatic server certificate needs replacing
+var AutoCertCheckInterval = 12 * time.Hour
+
+// privateRanges are the addresses a generated certificate authority may
+// always sign for, so a new local address doesn't need a new authority
+var privateRanges = []string{
+	"127.0.0.0/8", "::1/128",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+	"100.64.0.0/10", "fc00::/7",
+}
+
+// AutoCerts are the files generated for auto TLS
+type AutoCerts struct {
+	CACert   []byte
+	CertFile string
+	KeyFile  string
+}
+
+// EnsureAutoCerts loads the certificate authority and server certificate
+// from the config's directory, creating them on first start. The server
+// certificate is replaced when it's close to expiring or doesn't cover all
+// of the host's current names and addresses. Names the authority isn't
+// allowed to sign for are left out with a warning.
+func EnsureAutoCerts(config AutoTLSConfig) (*AutoCerts, error) {
+	dir := config.Dir
+	if dir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("could not find a directory for certificates, set auto_tls.dir: %w", err)
+		}
+		dir = filepath.Join(configDir, "netclip", "tls")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	dnsNames, ips := hostNames(config.Names)
+	ca, caKey, caPEM, err := loadOrCreateCA(dir, dnsNames, ips)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up certificate authority: %w", err)
+	}
+	dnsNames, ips = permittedNames(ca, dir, dnsNames, ips)
+
+	certs := &AutoCerts{
+		CACert:   caPEM,
+		CertFile: filepath.Join(dir, serverCertFile),
+		KeyFile:  filepath.Join(dir, serverKeyFile),
+	}
+
+	if current, err := readCertificate(certs.CertFile); err == nil && certificateCovers(current, ca, dnsNames, ips) {
+		return certs, nil
+	}
+
+	log.Printf("Creating a server certificate for %s", strings.Join(append(dnsNames, ipStrings(ips)...), ", "))
+	if err := createServerCert(certs.CertFile, certs.KeyFile, ca, caKey, dnsNames, ips); err != nil {
+		return nil, fmt.Errorf("could not create server certificate: %w", err)
+	}
+	return certs, nil
+}
+
+// renewAutoCerts replaces the automatic server certificate when it's due,
+// until ctx is done. Servers pick up the new files through their
+// CertReloader.
+func (a *App) renewAutoCerts(ctx context.Context) {
+	ticker := time.NewTicker(AutoCertCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.mu.RLock()
+			config := a.config.AutoTLS
+			a.mu.RUnlock()
+
+			if _, err := EnsureAutoCerts(config); err != nil {
+				log.Printf("Could not renew the server certificate: %v", err)
+			}
+		}
+	}
+}
+
+func loadOrCreateCA(dir string, dnsNames []string, ips []net.IP) (*x509.Certificate, crypto.Signer, []byte, error) {
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	certPEM, err := os.ReadFile(certPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Creating a certificate authority in %s", dir)
+		if err := createCA(certPath, keyPath, dnsNames, ips); err != nil {
+			return nil, nil, nil, err
+		}
+		certPEM, err = os.ReadFile(certPath)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := readKey(keyPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cert, key, certPEM, nil
+}
+
+// createCA makes a certificate authority that can only sign for the host's
+// names, private addresses and the host's other addresses, so its key
+// can't be used to impersonate other sites
+func createCA(certPath, keyPath string, dnsNames []string, ips []net.IP) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	var ranges []*net.IPNet
+	for _, cidr := range privateRanges {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		ranges = append(ranges, ipNet)
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(ranges, func(r *net.IPNet) bool { return r.Contains(ip) }) {
+			ranges = append(ranges, hostNet(ip))
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:                serialNumber(),
+		Subject:                     pkix.Name{CommonName: "netclip CA " + hostname, Organization: []string{"netclip"}},
+		NotBefore:                   now.Add(-time.Hour),
+		NotAfter:                    now.Add(caLifetime),
+		KeyUsage:                    x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid:       true,
+		IsCA:                        true,
+		MaxPathLenZero:              true,
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         dnsNames,
+		PermittedIPRanges:           ranges,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	return writeCertAndKey(certPath, keyPath, der, key)
+}
+
+func createServerCert(certPath, keyPath string, ca *x509.Certificate, caKey crypto.Signer, dnsNames []string, ips []net.IP) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: dnsNames[0], Organization: []string{"netclip"}},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(serverLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return err
+	}
+	return writeCertAndKey(certPath, keyPath, der, key)
+}
+
+// certificateCovers reports whether a server certificate was signed by the
+// CA, isn't about to expire, and names every host name and address
+func certificateCovers(cert, ca *x509.Certificate, dnsNames []string, ips []net.IP) bool {
+	if cert.CheckSignatureFrom(ca) != nil || time.Until(cert.NotAfter) < renewBefore {
+		return false
+	}
+	for _, name := range dnsNames {
+		if !slices.Contains(cert.DNSNames, name) {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// permittedNames drops the names and addresses the CA can't sign for,
+// logging each one. A CA made before netclip added name constraints can sign
+// for anything.
+func permittedNames(ca *x509.Certificate, dir string, dnsNames []string, ips []net.IP) ([]string, []net.IP) {
+	var names []string
+	for _, name := range dnsNames {
+		if len(ca.PermittedDNSDomains) == 0 || slices.ContainsFunc(ca.PermittedDNSDomains, func(domain string) bool {
+			return name == domain || strings.HasSuffix(name, "."+strings.TrimPrefix(domain, "."))
+		}) {
+			names = append(names, name)
+		} else {
+			log.Printf("The certificate authority in %s can't sign for %s, remove %s and %s to make a new one", dir, name, caCertFile, caKeyFile)
+		}
+	}
+
+	var addrs []net.IP
+	for _, ip := range ips {
+		if len(ca.PermittedIPRanges) == 0 || slices.ContainsFunc(ca.PermittedIPRanges, func(r *net.IPNet) bool { return r.Contains(ip) }) {
+			addrs = append(addrs, ip)
+		} else {
+			log.Printf("The certificate authority in %s can't sign for %s, remove %s and %s to make a new one", dir, ip, caCertFile, caKeyFile)
+		}
+	}
+	return names, addrs
+}
+
+// hostNet is the network holding just ip
+func hostNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// hostNames lists the names and addresses this host can be reached by,
+// along with any extra ones from the config
+func hostNames(extra []string) ([]string, []net.IP) {
+	dnsNames := []string{"localhost"}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+	addName := func(name string) {
+		if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+			if !slices.ContainsFunc(ips, ip.Equal) {
+				ips = append(ips, ip)
+			}
+		} else if name != "" && !slices.Contains(dnsNames, name) {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		hostname = strings.ToLower(hostname)
+		addName(hostname)
+		if !strings.Contains(hostname, ".") {
+			addName(hostname + ".local")
+		}
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			ip, _, err := net.ParseCIDR(addr.String())
+			// Link-local addresses change and need a zone, so they're no use
+			// in a certificate
+			if err == nil && !ip.IsLinkLocalUnicast() {
+				addName(ip.String())
+			}
+		}
+	}
+
+	for _, name := range extra {
+		addName(strings.ToLower(name))
+	}
+
+	return dnsNames, ips
+}
+
+func ipStrings(ips []net.IP) []string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return s
+}
+
+func serialNumber() *big.Int {
+	return new(big.Int).SetBytes(randomBytes(16))
+}
+
+func writeCertAndKey(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return os.WriteFile(certPath, certPEM, 0644)
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(data)
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no key found in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s does not hold a signing key", path)
+	}
+	return signer, nil
+}
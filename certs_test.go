@@ -0,0 +1,170 @@
+package netclip_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadServerCert(t *testing.T, certs *netclip.AutoCerts) *x509.Certificate {
+	pair, err := tls.LoadX509KeyPair(certs.CertFile, certs.KeyFile)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	assert.NoError(t, err)
+	return cert
+}
+
+func TestEnsureAutoCerts(t *testing.T) {
+	dir := t.TempDir()
+	config := netclip.AutoTLSConfig{Dir: dir, Names: []string{"clips.example.com", "192.0.2.10"}}
+
+	certs, err := netclip.EnsureAutoCerts(config)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(dir, "ca.key"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(certs.CACert))
+
+	cert := loadServerCert(t, certs)
+	for _, name := range []string{"localhost", "clips.example.com", "127.0.0.1", "192.0.2.10"} {
+		_, err := cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+		assert.NoError(t, err, name)
+	}
+
+	// The next start reuses what's there
+	again, err := netclip.EnsureAutoCerts(config)
+	assert.NoError(t, err)
+	assert.Equal(t, certs.CACert, again.CACert)
+	assert.Equal(t, cert.SerialNumber, loadServerCert(t, again).SerialNumber)
+
+	// A new name gets a new server certificate from the same authority
+	config.Names = append(config.Names, "eu.clips.example.com")
+	renamed, err := netclip.EnsureAutoCerts(config)
+	assert.NoError(t, err)
+	assert.Equal(t, certs.CACert, renamed.CACert)
+
+	cert = loadServerCert(t, renamed)
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "eu.clips.example.com", Roots: roots})
+	assert.NoError(t, err)
+}
+
+func TestEnsureAutoCertsConstrainsCA(t *testing.T) {
+	config := netclip.AutoTLSConfig{Dir: t.TempDir(), Names: []string{"clips.example.com", "192.0.2.10"}}
+	certs, err := netclip.EnsureAutoCerts(config)
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode(certs.CACert)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.Contains(t, ca.PermittedDNSDomains, "clips.example.com")
+	assert.NotEmpty(t, ca.PermittedIPRanges)
+
+	// Names outside the authority's constraints are left out rather than
+	// making a certificate nothing would accept
+	config.Names = append(config.Names, "bank.example.net", "198.51.100.7")
+	renamed, err := netclip.EnsureAutoCerts(config)
+	assert.NoError(t, err)
+
+	cert := loadServerCert(t, renamed)
+	assert.Contains(t, cert.DNSNames, "clips.example.com")
+	assert.NotContains(t, cert.DNSNames, "bank.example.net")
+	for _, ip := range cert.IPAddresses {
+		assert.NotEqual(t, "198.51.100.7", ip.String())
+	}
+}
+
+func TestRunRenewsAutoCerts(t *testing.T) {
+	interval := netclip.AutoCertCheckInterval
+	netclip.AutoCertCheckInterval = 10 * time.Millisecond
+	defer func() { netclip.AutoCertCheckInterval = interval }()
+
+	dir := t.TempDir()
+	app, err := netclip.NewApp(netclip.Config{Port: freePort(t), AutoTLS: netclip.AutoTLSConfig{Enabled: true, Dir: dir}})
+	assert.NoError(t, err)
+	defer app.Close()
+
+	// Swap in a certificate the app's authority didn't sign, as if the
+	// old one had expired
+	other, err := netclip.EnsureAutoCerts(netclip.AutoTLSConfig{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	for from, to := range map[string]string{other.CertFile: "server.crt", other.KeyFile: "server.key"} {
+		data, err := os.ReadFile(from)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, to), data, 0600))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.RunListeners(ctx, "")
+	}()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(ca))
+
+	assert.Eventually(t, func() bool {
+		cert, err := os.ReadFile(filepath.Join(dir, "server.crt"))
+		if err != nil {
+			return false
+		}
+		block, _ := pem.Decode(cert)
+		if block == nil {
+			return false
+		}
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return false
+		}
+		_, err = parsed.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCACertHandler(t *testing.T) {
+	app, err := netclip.NewApp(netclip.Config{AutoTLS: netclip.AutoTLSConfig{Enabled: true, Dir: t.TempDir()}})
+	assert.NoError(t, err)
+	defer app.Close()
+
+	req := httptest.NewRequest("GET", "/ca.crt", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	block, _ := pem.Decode(rr.Body.Bytes())
+	assert.NotNil(t, block)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.True(t, ca.IsCA)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), `<a href="/ca.crt">`)
+}
+
+func TestCACertHandlerOnlyWithAutoTLS(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ca.crt", nil)
+	rr := httptest.NewRecorder()
+	newApp(t).ServeHTTP(rr, req)
+	assert.NotContains(t, rr.Body.String(), "CERTIFICATE")
+}
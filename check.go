@@ -0,0 +1,301 @@
+package netclip
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is a problem with a config file, pointing at the setting and
+// line where it was found
+type ConfigError struct {
+	File string
+	// Line is zero when the problem isn't with a single line
+	Line int
+	// Setting is the setting's path, like listeners[0].port
+	Setting string
+	Err     error
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.File)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d", e.Line)
+	}
+	if e.Setting != "" {
+		if b.Len() > 0 {
+			b.WriteString(": ")
+		}
+		b.WriteString(e.Setting)
+	}
+	if b.Len() > 0 {
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	yamlLineError  = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlFieldError = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// decodeErrors splits a YAML error into one ConfigError for each line it
+// complains about
+func decodeErrors(path string, err error) []*ConfigError {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	var errs []*ConfigError
+	for _, message := range messages {
+		configErr := &ConfigError{File: path}
+		if m := yamlLineError.FindStringSubmatch(message); m != nil {
+			configErr.Line, _ = strconv.Atoi(m[1])
+			message = m[2]
+		}
+		if m := yamlFieldError.FindStringSubmatch(message); m != nil {
+			message = "unknown setting " + m[1]
+		}
+		configErr.Err = errors.New(message)
+		errs = append(errs, configErr)
+	}
+	return errs
+}
+
+// CheckConfigFiles loads config files, lowest precedence first, and checks
+// every setting in them. It returns all the problems found rather than just
+// the first, each pointing at the file that set the setting.
+func CheckConfigFiles(paths ...string) (Config, []*ConfigError) {
+	files, err := readConfigFiles(paths)
+	var fileErr *configFileError
+	if errors.As(err, &fileErr) {
+		return Config{}, decodeErrors(fileErr.path, fileErr.err)
+	}
+	if err != nil {
+		return Config{}, []*ConfigError{{Err: err}}
+	}
+
+	config, err := mergeConfigFiles(files)
+	if err != nil {
+		return Config{}, []*ConfigError{{Err: err}}
+	}
+
+	errs := CheckConfig(config)
+	for _, e := range errs {
+		e.File, e.Line = findSetting(files, e.Setting)
+	}
+	return config, errs
+}
+
+// findSetting finds the file and line that set a setting, looking at the
+// files that win first. Settings that no file sets point at the closest
+// line in the last file.
+func findSetting(files []configFile, setting string) (string, int) {
+	if len(files) == 0 {
+		return "", 0
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		if line, found := settingLine(files[i].root, setting); found {
+			return files[i].path, line
+		}
+	}
+	last := files[len(files)-1]
+	line, _ := settingLine(last.root, setting)
+	return last.path, line
+}
+
+// settingPart matches one step of a setting's path, like listeners[0]
+var settingPart = regexp.MustCompile(`^([a-z_]+)(?:\[(\d+)\])?$`)
+
+// settingLine finds the line a setting is on in a file's top-level mapping,
+// or the closest line above it when the file doesn't set it
+func settingLine(root *yaml.Node, setting string) (int, bool) {
+	if root == nil || setting == "" {
+		return 0, false
+	}
+	node, line := root, 0
+
+	for _, part := range strings.Split(setting, ".") {
+		m := settingPart.FindStringSubmatch(part)
+		if m == nil || node.Kind != yaml.MappingNode {
+			return line, false
+		}
+
+		var value *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == m[1] {
+				line, value = node.Content[i].Line, node.Content[i+1]
+				break
+			}
+		}
+		if value == nil {
+			return line, false
+		}
+
+		if m[2] != "" {
+			index, _ := strconv.Atoi(m[2])
+			if value.Kind != yaml.SequenceNode || index >= len(value.Content) {
+				return line, false
+			}
+			value = value.Content[index]
+			line = value.Line
+		}
+		node = value
+	}
+	return line, true
+}
+
+// configChecker collects problems with settings
+type configChecker struct {
+	errs []*ConfigError
+}
+
+func (c *configChecker) check(setting string, err error) {
+	if err != nil {
+		c.errs = append(c.errs, &ConfigError{Setting: setting, Err: err})
+	}
+}
+
+func (c *configChecker) checkPort(setting, port string) {
+	if port == "" {
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		c.check(setting, fmt.Errorf("%q is not a port number from 1 to 65535", port))
+	}
+}
+
+// checkAddresses accepts IP addresses and host names, like localhost, which
+// are looked up when netclip starts listening
+func (c *configChecker) checkAddresses(setting string, addresses []string) {
+	for i, addr := range addresses {
+		if _, err := netip.ParseAddr(strings.Trim(addr, "[]")); err != nil && !isHostname(addr) {
+			c.check(fmt.Sprintf("%s[%d]", setting, i), fmt.Errorf("%q is not an IP address or host name", addr))
+		}
+	}
+}
+
+// isHostname reports whether name is made of valid DNS labels
+func isHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *configChecker) checkPrefixes(setting string, entries []string) {
+	for i, entry := range entries {
+		if _, err := parsePrefixes([]string{entry}); err != nil {
+			c.check(fmt.Sprintf("%s[%d]", setting, i), fmt.Errorf("%q is not an IP address or CIDR block", entry))
+		}
+	}
+}
+
+func (c *configChecker) checkAccess(prefix string, access AccessConfig) {
+	c.checkPrefixes(prefix+"trusted_proxies", access.TrustedProxies)
+	for _, rule := range []struct {
+		name string
+		AccessRule
+	}{{"read", access.Read}, {"write", access.Write}, {"admin", access.Admin}} {
+		c.checkPrefixes(prefix+rule.name+".allow", rule.Allow)
+		c.checkPrefixes(prefix+rule.name+".deny", rule.Deny)
+	}
+}
+
+func (c *configChecker) checkReadable(setting, path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		c.check(setting, err)
+		return
+	}
+	f.Close()
+}
+
+// checkCertPair makes sure a certificate has a key and both can be read
+func (c *configChecker) checkCertPair(prefix, certFile, keyFile string) {
+	if certFile != "" && keyFile == "" {
+		c.check(prefix+"cert_file", errors.New("needs key_file too"))
+	}
+	if keyFile != "" && certFile == "" {
+		c.check(prefix+"key_file", errors.New("needs cert_file too"))
+	}
+	c.checkReadable(prefix+"cert_file", certFile)
+	c.checkReadable(prefix+"key_file", keyFile)
+}
+
+func (c *configChecker) checkNotNegative(setting string, negative bool) {
+	if negative {
+		c.check(setting, errors.New("can't be negative"))
+	}
+}
+
+// CheckConfig checks every setting, including that the files it names can
+// be read, and returns all the problems it finds
+func CheckConfig(config Config) []*ConfigError {
+	var c configChecker
+
+	c.checkPort("port", config.Port)
+	c.checkAddresses("addresses", config.Addresses)
+	c.checkCertPair("", config.CertFile, config.KeyFile)
+	c.checkReadable("encryption.key_file", os.ExpandEnv(config.Encryption.KeyFile))
+	if config.UsesTailscale() {
+		c.checkReadable("tailscale.auth_key_file", os.ExpandEnv(config.Tailscale.AuthKeyFile))
+	}
+	c.check("tailscale", config.Tailscale.Validate())
+	c.check("acme", config.ACME.Validate())
+	c.checkPort("acme.http_port", config.ACME.HTTPPort)
+	c.checkReadable("acme.ca_root", config.ACME.CARoot)
+	c.check("mode", config.Mode.Validate())
+	c.checkAccess("access.", config.Access)
+	c.checkNotNegative("rate_limit.read.requests_per_minute", config.RateLimit.Read.RequestsPerMinute < 0)
+	c.checkNotNegative("rate_limit.read.burst", config.RateLimit.Read.Burst < 0)
+	c.checkNotNegative("rate_limit.write.requests_per_minute", config.RateLimit.Write.RequestsPerMinute < 0)
+	c.checkNotNegative("rate_limit.write.burst", config.RateLimit.Write.Burst < 0)
+	c.checkNotNegative("secret_scan.flagged_ttl", config.SecretScan.FlaggedTTL < 0)
+	c.checkNotNegative("max_passphrase_checks", config.MaxPassphraseChecks < 0)
+
+	for i, l := range config.Listeners {
+		prefix := fmt.Sprintf("listeners[%d].", i)
+		c.check(prefix+"mode", l.Mode.Validate())
+		c.checkPort(prefix+"port", l.Port)
+		c.checkAddresses(prefix+"addresses", l.Addresses)
+		c.checkCertPair(prefix, l.CertFile, l.KeyFile)
+		if l.Access != nil {
+			c.checkAccess(prefix+"access.", *l.Access)
+		}
+	}
+
+	// Settings that are fine on their own can still clash with each other
+	if len(c.errs) == 0 {
+		c.check("", validateConfig(config))
+	}
+	return c.errs
+}
@@ -0,0 +1,125 @@
+package netclip_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "netclip.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func errorStrings(errs []*netclip.ConfigError) []string {
+	var s []string
+	for _, err := range errs {
+		s = append(s, err.Error())
+	}
+	return s
+}
+
+func TestLoadConfigRejectsUnknownSettings(t *testing.T) {
+	path := writeConfig(t, "port: \"4000\"\nprot: \"4000\"\n")
+
+	_, err := netclip.LoadConfig(path)
+	assert.EqualError(t, err, path+":2: unknown setting prot")
+}
+
+func TestCheckConfigFileUnknownSettings(t *testing.T) {
+	path := writeConfig(t, `port: "4000"
+tailscale:
+  enabled: true
+  host_name: clips
+listeners:
+  - port: "4001"
+    cert: server.crt
+`)
+
+	_, errs := netclip.CheckConfigFiles(path)
+	assert.Equal(t, []string{
+		path + ":4: unknown setting host_name",
+		path + ":7: unknown setting cert",
+	}, errorStrings(errs))
+}
+
+func TestCheckConfigFileSettings(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "server.crt")
+	assert.NoError(t, os.WriteFile(cert, []byte("cert"), 0600))
+
+	path := writeConfig(t, `port: "99999"
+cert_file: `+cert+`
+mode: sideways
+access:
+  write:
+    allow:
+      - 192.168.1.0/24
+      - lan
+listeners:
+  - port: "4001"
+  - port: "4002"
+    cert_file: `+cert+`
+    key_file: `+filepath.Join(dir, "missing.key")+`
+`)
+
+	_, errs := netclip.CheckConfigFiles(path)
+	assert.Equal(t, []string{
+		path + `:1: port: "99999" is not a port number from 1 to 65535`,
+		path + ":2: cert_file: needs key_file too",
+		path + `:3: mode: unknown mode "sideways", use normal, read-only or write-only`,
+		path + `:8: access.write.allow[1]: "lan" is not an IP address or CIDR block`,
+		path + ":13: listeners[1].key_file: open " + filepath.Join(dir, "missing.key") + ": no such file or directory",
+	}, errorStrings(errs))
+}
+
+func TestCheckConfigFileConflicts(t *testing.T) {
+	path := writeConfig(t, `listeners:
+  - tailscale: true
+  - tailscale: true
+`)
+
+	_, errs := netclip.CheckConfigFiles(path)
+	assert.Equal(t, []string{path + ": only one listener can be on the tailnet"}, errorStrings(errs))
+}
+
+func TestCheckConfigFileAuthKeyOnlyForTailscale(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.key")
+
+	_, errs := netclip.CheckConfigFiles(writeConfig(t, "tailscale:\n  auth_key_file: "+missing+"\n"))
+	assert.Empty(t, errs)
+
+	path := writeConfig(t, "tailscale:\n  enabled: true\n  auth_key_file: "+missing+"\n")
+	_, errs = netclip.CheckConfigFiles(path)
+	assert.Equal(t, []string{path + ":3: tailscale.auth_key_file: open " + missing + ": no such file or directory"}, errorStrings(errs))
+}
+
+func TestCheckConfigFileAddresses(t *testing.T) {
+	path := writeConfig(t, `addresses: ["192.168.1.10", "clips.lan", "not a host", "-bad"]
+`)
+
+	_, errs := netclip.CheckConfigFiles(path)
+	assert.Equal(t, []string{
+		path + `:1: addresses[2]: "not a host" is not an IP address or host name`,
+		path + `:1: addresses[3]: "-bad" is not an IP address or host name`,
+	}, errorStrings(errs))
+}
+
+func TestCheckConfigFileValid(t *testing.T) {
+	path := writeConfig(t, `port: "4000"
+addresses: ["127.0.0.1", "::1", "localhost"]
+mode: read-only
+`)
+
+	config, errs := netclip.CheckConfigFiles(path)
+	assert.Empty(t, errs)
+	assert.Equal(t, "4000", config.Port)
+
+	_, errs = netclip.CheckConfigFiles(writeConfig(t, ""))
+	assert.Empty(t, errs)
+}
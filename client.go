@@ -0,0 +1,138 @@
+package netclip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a netclip server from the command line
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Passphrase protects pasted clips and unlocks fetched ones
+	Passphrase string
+}
+
+// apiClip is the JSON body of the clips API
+type apiClip struct {
+	Text       string    `json:"text,omitempty"`
+	Passphrase string    `json:"passphrase,omitempty"`
+	Key        string    `json:"key,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Secrets    []string  `json:"secrets,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Paste saves text as a new clip and returns its link. With e2e set, the text
+// is encrypted before it leaves this machine and the key is added to the
+// link's fragment. The link is empty when the server is write-only, since
+// nobody can view the clip.
+func (c *Client) Paste(text string, e2e bool) (string, error) {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+
+	var fragment string
+	if e2e {
+		if text, fragment, err = EncryptE2E(text); err != nil {
+			return "", err
+		}
+	}
+
+	body, err := json.Marshal(apiClip{Text: text, Passphrase: c.Passphrase})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.httpClient().Post(base.JoinPath("api", "clips").String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError(resp)
+	}
+
+	var created apiClip
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+
+	if created.URL == "" {
+		return "", nil
+	}
+	link := base.JoinPath("clip", created.Key)
+	link.Fragment = fragment
+	return link.String(), nil
+}
+
+// Get fetches a clip by its link. If the link carries an end-to-end
+// encryption key in its fragment, the clip is decrypted.
+func (c *Client) Get(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	i := strings.LastIndex(u.Path, "/clip/")
+	if i < 0 || strings.HasSuffix(u.Path, "/clip/") {
+		return "", fmt.Errorf("%s is not a clip link", link)
+	}
+
+	raw := *u
+	raw.Path = u.Path[:i] + "/raw/" + u.Path[i+len("/clip/"):]
+	raw.RawPath = ""
+	raw.Fragment = ""
+
+	req, err := http.NewRequest(http.MethodGet, raw.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.Passphrase != "" {
+		req.Header.Set(passphraseHeader, c.Passphrase)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	text := string(data)
+	if !IsE2E(text) {
+		return text, nil
+	}
+	if u.Fragment == "" {
+		return "", fmt.Errorf("clip is end-to-end encrypted and the link has no key")
+	}
+	return DecryptE2E(text, u.Fragment)
+}
+
+func responseError(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+}
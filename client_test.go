@@ -0,0 +1,83 @@
+package netclip_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newClipServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(newApp(t))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientPasteAndGet(t *testing.T) {
+	server := newClipServer(t)
+	client := &netclip.Client{BaseURL: server.URL}
+
+	link, err := client.Paste("from the command line", false)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link, server.URL+"/clip/"))
+	assert.NotContains(t, link, "#")
+
+	text, err := client.Get(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "from the command line", text)
+}
+
+func TestClientPasteE2E(t *testing.T) {
+	server := newClipServer(t)
+	client := &netclip.Client{BaseURL: server.URL}
+
+	link, err := client.Paste("for your eyes only", true)
+	assert.NoError(t, err)
+	assert.Contains(t, link, "#")
+
+	// The server only has ciphertext
+	raw, err := client.Get(strings.Split(link, "#")[0])
+	assert.Error(t, err)
+	assert.Empty(t, raw)
+
+	text, err := client.Get(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "for your eyes only", text)
+}
+
+func TestClientGetRejectsOtherLinks(t *testing.T) {
+	client := &netclip.Client{}
+	_, err := client.Get("http://localhost:9999/static/app.js")
+	assert.Error(t, err)
+}
+
+func TestClientPassphrase(t *testing.T) {
+	server := newClipServer(t)
+	client := &netclip.Client{BaseURL: server.URL, Passphrase: "open sesame"}
+
+	link, err := client.Paste("protected from the command line", false)
+	assert.NoError(t, err)
+
+	_, err = (&netclip.Client{}).Get(link)
+	assert.Error(t, err)
+
+	text, err := client.Get(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "protected from the command line", text)
+}
+
+func TestClientPasteWriteOnly(t *testing.T) {
+	app, err := netclip.NewApp(netclip.Config{Mode: netclip.ModeWriteOnly})
+	assert.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, app.Close()) })
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+
+	client := &netclip.Client{BaseURL: server.URL}
+	link, err := client.Paste("into the box", false)
+	assert.NoError(t, err)
+	assert.Empty(t, link)
+}
@@ -1,41 +1,76 @@
 package netclip
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
-
-	"gopkg.in/yaml.v3"
+	"strings"
 )
 
 type Config struct {
-	Port     string      `yaml:"port"`
-	CertFile string      `yaml:"cert_file"`
-	KeyFile  string      `yaml:"key_file"`
-	Tailscale    TailscaleConfig `yaml:"tailscale"`
+	Port       string           `yaml:"port"`
+	Addresses  []string         `yaml:"addresses"`
+	CertFile   string           `yaml:"cert_file"`
+	KeyFile    string           `yaml:"key_file"`
+	AutoTLS    AutoTLSConfig    `yaml:"auto_tls"`
+	ACME       ACMEConfig       `yaml:"acme"`
+	Mode       Mode             `yaml:"mode"`
+	DataFile   string           `yaml:"data_file"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+	Tailscale  TailscaleConfig  `yaml:"tailscale"`
+	Access     AccessConfig     `yaml:"access"`
+	RateLimit  RateLimitConfig  `yaml:"rate_limit"`
+	Audit      AuditConfig      `yaml:"audit"`
+	SecretScan SecretScanConfig `yaml:"secret_scan"`
+	Listeners  []ListenerConfig `yaml:"listeners"`
+	// MaxPassphraseChecks is how many clip passphrases can be checked at
+	// once, 4 when zero
+	MaxPassphraseChecks int `yaml:"max_passphrase_checks"`
 }
 
 type TailscaleConfig struct {
 	Enabled  bool   `yaml:"enabled"`
 	Hostname string `yaml:"hostname"`
 	UseTLS   bool   `yaml:"use_tls"`
+	// AuthKeyFile holds the auth key, when TS_AUTHKEY isn't set
+	AuthKeyFile string `yaml:"auth_key_file"`
+	// StateDir keeps the node's keys and state. tsnet picks a directory in
+	// the user's config directory when it's empty.
+	StateDir string `yaml:"state_dir"`
+	// Ephemeral nodes are removed from the tailnet soon after they go
+	// offline
+	Ephemeral bool `yaml:"ephemeral"`
+	// Tags the node advertises when it registers, like tag:netclip. netclip
+	// won't start if the tailnet doesn't grant them.
+	Tags []string `yaml:"tags"`
+	// ControlURL is the coordination server, such as a Headscale server.
+	// It defaults to Tailscale's.
+	ControlURL string `yaml:"control_url"`
 }
 
-// LoadConfig loads the configuration file from the given path
-func LoadConfig(configFile string) (Config, error) {
-	data, err := os.ReadFile(configFile)
-	if err != nil {
-		return Config{}, err
+// Validate checks the tags and control URL before joining the tailnet
+func (c TailscaleConfig) Validate() error {
+	for _, tag := range c.Tags {
+		if !strings.HasPrefix(tag, "tag:") || tag == "tag:" {
+			return fmt.Errorf("tailscale tag %q should look like tag:netclip", tag)
+		}
 	}
-
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return Config{}, err
+	if c.ControlURL != "" {
+		u, err := url.Parse(c.ControlURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("tailscale control_url %q is not an http or https URL", c.ControlURL)
+		}
 	}
+	return nil
+}
 
-
-	return config, nil
+// LoadConfig loads the configuration file from the given path, along with
+// any files it includes
+func LoadConfig(configFile string) (Config, error) {
+	return LoadConfigs(configFile)
 }
 
 // GetConfigPaths returns a list of paths to search for config files in priority order
@@ -103,50 +138,124 @@ func GetConfigPaths() []string {
 
 // LoadConfigFromPaths tries to load config from standard locations
 func LoadConfigFromPaths() (Config, error) {
-	var config Config
-	var err error
-	
+	config, _, err := FindConfig()
+	return config, err
+}
+
+// ErrNoConfig means none of the standard locations has a config file
+var ErrNoConfig = errors.New("no config file found")
+
+// FindConfigPaths returns the config files in the standard locations that
+// exist, lowest precedence first, so a user's file can override only some
+// of the system-wide settings
+func FindConfigPaths() []string {
+	var found []string
+	seen := make(map[string]bool)
+
 	configPaths := GetConfigPaths()
-	
-	for _, path := range configPaths {
-		config, err = LoadConfig(path)
-		if err == nil {
-			return config, nil
+
+	for i := len(configPaths) - 1; i >= 0; i-- {
+		path := configPaths[i]
+		if abs, err := filepath.Abs(path); err == nil {
+			if seen[abs] {
+				continue
+			}
+			seen[abs] = true
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			found = append(found, path)
 		}
 	}
-	
-	return Config{}, err
+
+	return found
+}
+
+// FindConfig loads and merges every config file in the standard locations
+// and returns their paths too, so they can be reloaded. A file that exists
+// but can't be loaded is an error rather than being skipped.
+func FindConfig() (Config, []string, error) {
+	paths := FindConfigPaths()
+	if len(paths) == 0 {
+		return Config{}, nil, ErrNoConfig
+	}
+
+	config, err := LoadConfigs(paths...)
+	return config, paths, err
+}
+
+// Flags are command line flag values that override config settings. Empty
+// strings and false leave the config's setting alone.
+type Flags struct {
+	Port string
+	// Addresses and TailscaleTags are comma separated
+	Addresses           string
+	CertFile            string
+	KeyFile             string
+	Tailscale           bool
+	TailscaleHostname   string
+	TailscaleTLS        bool
+	TailscaleStateDir   string
+	TailscaleEphemeral  bool
+	TailscaleTags       string
+	TailscaleControlURL string
 }
 
 // ApplyFlags applies command line flag values to the config, with flags taking precedence
-func ApplyFlags(config Config, port, certFile, keyFile, tailscaleHostname string, tailscaleEnabled, tailscaleTLS bool) Config {
+func ApplyFlags(config Config, flags Flags) Config {
 	// Port handling
-	if port != "" {
-		config.Port = port
+	if flags.Port != "" {
+		config.Port = flags.Port
 	} else if config.Port == "" {
 		config.Port = "9999"
 	}
 
+	if flags.Addresses != "" {
+		config.Addresses = splitList(flags.Addresses)
+	}
+
 	// SSL certificate handling
-	if certFile != "" {
-		config.CertFile = certFile
+	if flags.CertFile != "" {
+		config.CertFile = flags.CertFile
 	}
-	if keyFile != "" {
-		config.KeyFile = keyFile
+	if flags.KeyFile != "" {
+		config.KeyFile = flags.KeyFile
 	}
 
 	// Tailscale flag overrides
-	if tailscaleEnabled {
+	if flags.Tailscale {
 		config.Tailscale.Enabled = true
 	}
-	if tailscaleHostname != "" {
-		config.Tailscale.Hostname = tailscaleHostname
+	if flags.TailscaleHostname != "" {
+		config.Tailscale.Hostname = flags.TailscaleHostname
 	} else if config.Tailscale.Enabled && config.Tailscale.Hostname == "" {
 		config.Tailscale.Hostname = "netclip"
 	}
-	if tailscaleTLS {
+	if flags.TailscaleTLS {
 		config.Tailscale.UseTLS = true
 	}
+	if flags.TailscaleStateDir != "" {
+		config.Tailscale.StateDir = flags.TailscaleStateDir
+	}
+	if flags.TailscaleEphemeral {
+		config.Tailscale.Ephemeral = true
+	}
+	if flags.TailscaleTags != "" {
+		config.Tailscale.Tags = splitList(flags.TailscaleTags)
+	}
+	if flags.TailscaleControlURL != "" {
+		config.Tailscale.ControlURL = flags.TailscaleControlURL
+	}
 
 	return config
 }
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -99,22 +99,31 @@ tailscale:
 // ApplyFlags tests
 func TestApplyFlagsPortOverridesConfig(t *testing.T) {
 	config := netclip.Config{Port: "4000"}
-	result := netclip.ApplyFlags(config, "8080", "", "", "", false, false)
+	result := netclip.ApplyFlags(config, netclip.Flags{Port: "8080"})
 	assert.Equal(t, "8080", result.Port)
 }
 
 func TestApplyFlagsPortDefault(t *testing.T) {
 	config := netclip.Config{}
-	result := netclip.ApplyFlags(config, "", "", "", "", false, false)
+	result := netclip.ApplyFlags(config, netclip.Flags{})
 	assert.Equal(t, "9999", result.Port)
 }
 
+func TestApplyFlagsAddressesOverrideConfig(t *testing.T) {
+	config := netclip.Config{Addresses: []string{"0.0.0.0"}}
+	result := netclip.ApplyFlags(config, netclip.Flags{Addresses: "127.0.0.1, ::1"})
+	assert.Equal(t, []string{"127.0.0.1", "::1"}, result.Addresses)
+
+	result = netclip.ApplyFlags(config, netclip.Flags{})
+	assert.Equal(t, []string{"0.0.0.0"}, result.Addresses)
+}
+
 func TestApplyFlagsCertKeyOverrideConfig(t *testing.T) {
 	config := netclip.Config{
 		CertFile: "config.crt",
 		KeyFile:  "config.key",
 	}
-	result := netclip.ApplyFlags(config, "", "flag.crt", "flag.key", "", false, false)
+	result := netclip.ApplyFlags(config, netclip.Flags{CertFile: "flag.crt", KeyFile: "flag.key"})
 	assert.Equal(t, "flag.crt", result.CertFile)
 	assert.Equal(t, "flag.key", result.KeyFile)
 }
@@ -123,7 +132,7 @@ func TestApplyFlagsTailscaleEnablesOverConfig(t *testing.T) {
 	config := netclip.Config{
 		Tailscale: netclip.TailscaleConfig{Enabled: false},
 	}
-	result := netclip.ApplyFlags(config, "", "", "", "", true, false)
+	result := netclip.ApplyFlags(config, netclip.Flags{Tailscale: true})
 	assert.True(t, result.Tailscale.Enabled)
 }
 
@@ -134,13 +143,13 @@ func TestApplyFlagsTailscaleHostnameOverridesConfig(t *testing.T) {
 			Hostname: "config-host",
 		},
 	}
-	result := netclip.ApplyFlags(config, "", "", "", "flag-host", false, false)
+	result := netclip.ApplyFlags(config, netclip.Flags{TailscaleHostname: "flag-host"})
 	assert.Equal(t, "flag-host", result.Tailscale.Hostname)
 }
 
 func TestApplyFlagsTailscaleHostnameDefaultWhenEnabled(t *testing.T) {
 	config := netclip.Config{}
-	result := netclip.ApplyFlags(config, "", "", "", "", true, false)
+	result := netclip.ApplyFlags(config, netclip.Flags{Tailscale: true})
 	assert.Equal(t, "netclip", result.Tailscale.Hostname)
 }
 
@@ -148,10 +157,37 @@ func TestApplyFlagsTailscaleTLSOverridesConfig(t *testing.T) {
 	config := netclip.Config{
 		Tailscale: netclip.TailscaleConfig{UseTLS: false},
 	}
-	result := netclip.ApplyFlags(config, "", "", "", "", false, true)
+	result := netclip.ApplyFlags(config, netclip.Flags{TailscaleTLS: true})
 	assert.True(t, result.Tailscale.UseTLS)
 }
 
+func TestApplyFlagsTailscaleNodeSettingsOverrideConfig(t *testing.T) {
+	config := netclip.Config{
+		Tailscale: netclip.TailscaleConfig{
+			StateDir:   "/var/lib/config",
+			Tags:       []string{"tag:config"},
+			ControlURL: "https://config.example.com",
+		},
+	}
+	result := netclip.ApplyFlags(config, netclip.Flags{
+		TailscaleStateDir:   "/var/lib/flag",
+		TailscaleEphemeral:  true,
+		TailscaleTags:       "tag:netclip, ,tag:clips",
+		TailscaleControlURL: "https://flag.example.com",
+	})
+	assert.Equal(t, "/var/lib/flag", result.Tailscale.StateDir)
+	assert.True(t, result.Tailscale.Ephemeral)
+	assert.Equal(t, []string{"tag:netclip", "tag:clips"}, result.Tailscale.Tags)
+	assert.Equal(t, "https://flag.example.com", result.Tailscale.ControlURL)
+
+	// Without the flags, the config's settings stay
+	result = netclip.ApplyFlags(config, netclip.Flags{})
+	assert.Equal(t, config.Tailscale.StateDir, result.Tailscale.StateDir)
+	assert.False(t, result.Tailscale.Ephemeral)
+	assert.Equal(t, config.Tailscale.Tags, result.Tailscale.Tags)
+	assert.Equal(t, config.Tailscale.ControlURL, result.Tailscale.ControlURL)
+}
+
 func TestApplyFlagsConfigPreservedWhenNoFlags(t *testing.T) {
 	config := netclip.Config{
 		Port:     "4000",
@@ -163,7 +199,7 @@ func TestApplyFlagsConfigPreservedWhenNoFlags(t *testing.T) {
 			UseTLS:   true,
 		},
 	}
-	result := netclip.ApplyFlags(config, "", "", "", "", false, false)
+	result := netclip.ApplyFlags(config, netclip.Flags{})
 	assert.Equal(t, "4000", result.Port)
 	assert.Equal(t, "test.crt", result.CertFile)
 	assert.Equal(t, "test.key", result.KeyFile)
@@ -238,3 +274,114 @@ func TestGetConfigPathsXDGConfigHome(t *testing.T) {
 	assert.Contains(t, paths, expectedPath)
 }
 
+
+func TestLoadConfigAccessRules(t *testing.T) {
+	configContent := `access:
+  trusted_proxies: ["10.0.0.1"]
+  read:
+    allow: ["192.168.0.0/16"]
+  write:
+    allow: ["192.168.1.0/24"]
+    deny: ["192.168.1.99"]`
+
+	tmpfile, err := os.CreateTemp("", "netclip-config-*.yml")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte(configContent))
+	assert.NoError(t, err)
+	err = tmpfile.Close()
+	assert.NoError(t, err)
+
+	config, err := netclip.LoadConfig(tmpfile.Name())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"10.0.0.1"}, config.Access.TrustedProxies)
+	assert.Equal(t, []string{"192.168.0.0/16"}, config.Access.Read.Allow)
+	assert.Equal(t, []string{"192.168.1.0/24"}, config.Access.Write.Allow)
+	assert.Equal(t, []string{"192.168.1.99"}, config.Access.Write.Deny)
+}
+
+func TestLoadConfigRateLimits(t *testing.T) {
+	configContent := `rate_limit:
+  read:
+    requests_per_minute: 120
+    burst: 30
+  write:
+    requests_per_minute: 10
+    burst: 5`
+
+	tmpfile, err := os.CreateTemp("", "netclip-config-*.yml")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte(configContent))
+	assert.NoError(t, err)
+	err = tmpfile.Close()
+	assert.NoError(t, err)
+
+	config, err := netclip.LoadConfig(tmpfile.Name())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 120.0, config.RateLimit.Read.RequestsPerMinute)
+	assert.Equal(t, 30, config.RateLimit.Read.Burst)
+	assert.Equal(t, 10.0, config.RateLimit.Write.RequestsPerMinute)
+	assert.Equal(t, 5, config.RateLimit.Write.Burst)
+}
+
+func TestLoadConfigListeners(t *testing.T) {
+	configContent := `listeners:
+  - name: office
+    port: "9999"
+    access:
+      write:
+        allow: ["192.168.1.0/24"]
+  - name: remote
+    tailscale: true
+    mode: read-only`
+
+	tmpfile, err := os.CreateTemp("", "netclip-config-*.yml")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte(configContent))
+	assert.NoError(t, err)
+	err = tmpfile.Close()
+	assert.NoError(t, err)
+
+	config, err := netclip.LoadConfig(tmpfile.Name())
+	assert.NoError(t, err)
+
+	assert.Len(t, config.Listeners, 2)
+	assert.Equal(t, "9999", config.Listeners[0].Port)
+	assert.Equal(t, []string{"192.168.1.0/24"}, config.Listeners[0].Access.Write.Allow)
+	assert.True(t, config.Listeners[1].Tailscale)
+	assert.Equal(t, netclip.ModeReadOnly, config.Listeners[1].Mode)
+	assert.Nil(t, config.Listeners[1].Access)
+}
+
+func TestTailscaleConfigValidate(t *testing.T) {
+	valid := netclip.TailscaleConfig{
+		Tags:       []string{"tag:netclip", "tag:server"},
+		ControlURL: "https://headscale.example.com",
+	}
+	assert.NoError(t, valid.Validate())
+
+	tests := map[string]struct {
+		config netclip.TailscaleConfig
+		want   string
+	}{
+		"tag without prefix": {netclip.TailscaleConfig{Tags: []string{"netclip"}}, `tailscale tag "netclip" should look like tag:netclip`},
+		"empty tag name":     {netclip.TailscaleConfig{Tags: []string{"tag:"}}, `tailscale tag "tag:" should look like tag:netclip`},
+		"relative url":       {netclip.TailscaleConfig{ControlURL: "headscale.example.com"}, "tailscale control_url"},
+		"other scheme":       {netclip.TailscaleConfig{ControlURL: "ftp://headscale.example.com"}, "tailscale control_url"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.want)
+			}
+		})
+	}
+}
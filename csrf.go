@@ -0,0 +1,92 @@
+package netclip
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// sessionCookieName is the cookie that ties a browser to its CSRF token
+const sessionCookieName = "netclip_session"
+
+// csrfFieldName is the form field that carries the CSRF token
+const csrfFieldName = "csrf_token"
+
+var (
+	errOriginMismatch = errors.New("cross-origin request")
+	errMissingSession = errors.New("missing session")
+	errInvalidToken   = errors.New("invalid form token")
+)
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// csrfToken derives the token for a session ID by signing it with the
+// app's secret
+func csrfToken(secret []byte, sessionID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ensureSession returns the caller's session ID, setting a new session cookie
+// if they don't have one yet.
+func ensureSession(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	sessionID := base64.RawURLEncoding.EncodeToString(randomBytes(32))
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return sessionID
+}
+
+// checkOrigin rejects requests a browser sent from another site. Requests
+// without an Origin header come from non-browser clients and pass.
+func checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return errOriginMismatch
+	}
+	return nil
+}
+
+// verifyCSRF checks that a form submission came from a page this server
+// rendered with the same secret. The form must already be parsed.
+func verifyCSRF(r *http.Request, secret []byte) error {
+	if err := checkOrigin(r); err != nil {
+		return err
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return errMissingSession
+	}
+
+	token := r.PostForm.Get(csrfFieldName)
+	if !hmac.Equal([]byte(token), []byte(csrfToken(secret, cookie.Value))) {
+		return errInvalidToken
+	}
+	return nil
+}
@@ -1,34 +1,92 @@
 package netclip
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
 	"sort"
 	"sync"
+	"time"
 )
 
+// Clip is a saved piece of text
+type Clip struct {
+	Text string
+	// PasswordHash is set when viewing the clip needs a passphrase
+	PasswordHash string
+	// Secrets lists the kinds of secrets the clip seems to contain
+	Secrets []string
+	// ExpiresAt is when the clip is removed. Zero means never.
+	ExpiresAt time.Time
+}
+
+// Protected reports whether the clip needs a passphrase to view
+func (c Clip) Protected() bool {
+	return c.PasswordHash != ""
+}
+
+// Flagged reports whether the clip seems to contain secrets
+func (c Clip) Flagged() bool {
+	return len(c.Secrets) > 0
+}
+
+// Expired reports whether the clip has passed its expiry time
+func (c Clip) Expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
+}
+
 // DataStore holds our text data
 type DataStore struct {
-	data map[string]string
-	mu   sync.Mutex
+	data    map[string]Clip
+	mu      sync.Mutex
+	path    string
+	keyring *Keyring
+}
+
+// dataFile is the layout of the file clips are saved to
+type dataFile struct {
+	Clips map[string]storedClip `json:"clips"`
+}
+
+// storedClip is a clip as written to the data file. KeyID names the
+// encryption key when the body is encrypted.
+type storedClip struct {
+	Body         string    `json:"body"`
+	KeyID        string    `json:"key_id,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Secrets      []string  `json:"secrets,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
 }
 
 // NewDataStore initializes a new data store
 func NewDataStore() DataStore {
 	return DataStore{
-		data: make(map[string]string),
+		data: make(map[string]Clip),
 	}
 }
 
 // Store saves a field to the datastore
 func (ds *DataStore) Store(key, value string) {
+	ds.StoreClip(key, Clip{Text: value})
+}
+
+// StoreClip saves a clip along with its settings
+func (ds *DataStore) StoreClip(key string, clip Clip) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	ds.data[key] = value
+	ds.data[key] = clip
+	ds.persist()
 }
 
 // Range lets us loop over all the records.
 func (ds *DataStore) Range() map[string]string {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
+	ds.removeExpired()
 
 	keys := make([]string, 0, len(ds.data))
 	for key := range ds.data {
@@ -41,24 +99,183 @@ func (ds *DataStore) Range() map[string]string {
 
 	sortedData := make(map[string]string, len(ds.data))
 	for _, key := range keys {
-		sortedData[key] = ds.data[key]
+		sortedData[key] = ds.data[key].Text
 	}
 
 	return sortedData
 }
 
+// Clips returns a copy of every clip with its settings
+func (ds *DataStore) Clips() map[string]Clip {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.removeExpired()
+
+	clips := make(map[string]Clip, len(ds.data))
+	for key, clip := range ds.data {
+		clips[key] = clip
+	}
+	return clips
+}
+
 // Delete removes a record
 func (ds *DataStore) Delete(key string) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 	delete(ds.data, key)
+	ds.persist()
 }
 
 // GetValue gets the value at the key
 func (ds *DataStore) GetValue(key string) (string, bool) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
+	ds.removeExpired()
+
+	clip, ok := ds.data[key]
+	return clip.Text, ok
+}
+
+// GetClip gets the clip at the key along with its settings
+func (ds *DataStore) GetClip(key string) (Clip, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.removeExpired()
+
+	clip, ok := ds.data[key]
+	return clip, ok
+}
+
+// Flush writes every clip to the data file, if there is one
+func (ds *DataStore) Flush() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.save()
+}
 
-	value, ok := ds.data[key]
-	return value, ok
+// removeExpired drops clips past their expiry time. Callers must hold ds.mu.
+func (ds *DataStore) removeExpired() {
+	now := time.Now()
+	removed := false
+	for key, clip := range ds.data {
+		if clip.Expired(now) {
+			delete(ds.data, key)
+			removed = true
+		}
+	}
+	if removed {
+		ds.persist()
+	}
+}
+
+// UseFile loads clips from the file at path and saves every change back to
+// it. With a keyring, clip bodies are encrypted on disk, and clips that were
+// stored in plain text or with an old key are re-encrypted with the current key.
+func (ds *DataStore) UseFile(path string, keyring *Keyring) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.path = path
+	ds.keyring = keyring
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ds.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	var file dataFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	stale := 0
+	for key, clip := range file.Clips {
+		body := clip.Body
+		if clip.KeyID == "" {
+			if keyring != nil {
+				stale++
+			}
+		} else {
+			if keyring == nil {
+				return errNoKey
+			}
+			var oldKey bool
+			body, oldKey, err = keyring.Decrypt(key, clip.KeyID, clip.Body)
+			if err != nil {
+				return fmt.Errorf("clip %s: %w", key, err)
+			}
+			if oldKey {
+				stale++
+			}
+		}
+		ds.data[key] = Clip{
+			Text:         body,
+			PasswordHash: clip.PasswordHash,
+			Secrets:      clip.Secrets,
+			ExpiresAt:    clip.ExpiresAt,
+		}
+	}
+
+	if stale > 0 {
+		log.Printf("Re-encrypting %d clips with the current key", stale)
+		return ds.save()
+	}
+	return nil
+}
+
+// persist saves the clips if the store is backed by a file, logging any
+// failure. Callers must hold ds.mu.
+func (ds *DataStore) persist() {
+	if err := ds.save(); err != nil {
+		log.Printf("Could not save clips to %s: %v", ds.path, err)
+	}
+}
+
+// save writes all clips to the data file, replacing it in one step so a
+// crash can't leave it half written. Callers must hold ds.mu.
+func (ds *DataStore) save() error {
+	if ds.path == "" {
+		return nil
+	}
+
+	file := dataFile{Clips: make(map[string]storedClip, len(ds.data))}
+	for key, clip := range ds.data {
+		stored := storedClip{
+			Body:         clip.Text,
+			PasswordHash: clip.PasswordHash,
+			Secrets:      clip.Secrets,
+			ExpiresAt:    clip.ExpiresAt,
+		}
+		if ds.keyring != nil {
+			stored.KeyID, stored.Body = ds.keyring.Encrypt(key, clip.Text)
+		}
+		file.Clips[key] = stored
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ds.path), ".netclip-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ds.path)
 }
@@ -1,7 +1,11 @@
 package netclip_test
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"netclip"
 
@@ -33,3 +37,105 @@ func TestRange(t *testing.T) {
 	assert.Equal(t, "qux", data["baz"])
 	assert.Equal(t, "bar", data["foo"])
 }
+
+func TestUseFilePersistsClips(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clips.json")
+
+	ds := netclip.NewDataStore()
+	assert.NoError(t, ds.UseFile(path, nil))
+	ds.Store("foo", "bar")
+	ds.Store("baz", "qux")
+	ds.Delete("baz")
+
+	reloaded := netclip.NewDataStore()
+	assert.NoError(t, reloaded.UseFile(path, nil))
+	value, ok := reloaded.GetValue("foo")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", value)
+	_, ok = reloaded.GetValue("baz")
+	assert.False(t, ok)
+}
+
+func TestUseFileEncryptsClips(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clips.json")
+	keyring, err := netclip.NewKeyring(bytes.Repeat([]byte{1}, 32))
+	assert.NoError(t, err)
+
+	ds := netclip.NewDataStore()
+	assert.NoError(t, ds.UseFile(path, keyring))
+	ds.Store("foo", "AKIA-super-secret")
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "AKIA-super-secret")
+
+	reloaded := netclip.NewDataStore()
+	assert.NoError(t, reloaded.UseFile(path, keyring))
+	value, _ := reloaded.GetValue("foo")
+	assert.Equal(t, "AKIA-super-secret", value)
+
+	// Encrypted clips can't be loaded without the key
+	noKey := netclip.NewDataStore()
+	assert.Error(t, noKey.UseFile(path, nil))
+}
+
+func TestUseFileReencryptsOnKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clips.json")
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	// Start with a plain text file, then turn on encryption
+	ds := netclip.NewDataStore()
+	assert.NoError(t, ds.UseFile(path, nil))
+	ds.Store("foo", "bar")
+
+	oldKeyring, err := netclip.NewKeyring(oldKey)
+	assert.NoError(t, err)
+	ds = netclip.NewDataStore()
+	assert.NoError(t, ds.UseFile(path, oldKeyring))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `"bar"`)
+
+	// Rotate to a new key, keeping the old one to read existing clips
+	rotated, err := netclip.NewKeyring(newKey, oldKey)
+	assert.NoError(t, err)
+	ds = netclip.NewDataStore()
+	assert.NoError(t, ds.UseFile(path, rotated))
+
+	// Now the old key isn't needed any more
+	newKeyring, err := netclip.NewKeyring(newKey)
+	assert.NoError(t, err)
+	ds = netclip.NewDataStore()
+	assert.NoError(t, ds.UseFile(path, newKeyring))
+	value, _ := ds.GetValue("foo")
+	assert.Equal(t, "bar", value)
+}
+
+func TestUseFileKeepsClipSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clips.json")
+
+	ds := netclip.NewDataStore()
+	assert.NoError(t, ds.UseFile(path, nil))
+	ds.StoreClip("foo", netclip.Clip{Text: "bar", PasswordHash: "hash"})
+
+	reloaded := netclip.NewDataStore()
+	assert.NoError(t, reloaded.UseFile(path, nil))
+	clip, ok := reloaded.GetClip("foo")
+	assert.True(t, ok)
+	assert.True(t, clip.Protected())
+	assert.Equal(t, "bar", clip.Text)
+}
+
+func TestExpiredClipsAreRemoved(t *testing.T) {
+	ds := netclip.NewDataStore()
+	ds.StoreClip("old", netclip.Clip{Text: "gone", ExpiresAt: time.Now().Add(-time.Second)})
+	ds.StoreClip("new", netclip.Clip{Text: "still here", ExpiresAt: time.Now().Add(time.Hour)})
+
+	_, ok := ds.GetClip("old")
+	assert.False(t, ok)
+	_, ok = ds.GetClip("new")
+	assert.True(t, ok)
+	assert.Len(t, ds.Clips(), 1)
+}
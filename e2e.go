@@ -0,0 +1,96 @@
+package netclip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// E2EPrefix marks a clip that was encrypted by the client. The server only
+// ever sees the ciphertext; the key travels in the URL fragment of the
+// clip's link, which browsers never send to the server.
+//
+// The format is the prefix followed by the unpadded base64url encoding of a
+// 12-byte nonce and the AES-256-GCM ciphertext. The key is 32 bytes, also
+// unpadded base64url. static/app.js implements the same format.
+const E2EPrefix = "e2e:v1:"
+
+// Sizes of the AES-GCM nonce and authentication tag in E2E clips
+const (
+	e2eNonceSize = 12
+	e2eTagSize   = 16
+)
+
+var errBadE2EKey = errors.New("invalid end-to-end encryption key")
+
+// IsE2E reports whether a clip body is end-to-end encrypted
+func IsE2E(value string) bool {
+	return strings.HasPrefix(value, E2EPrefix)
+}
+
+// checkE2E rejects a clip body that claims to be end-to-end encrypted but
+// isn't the prefix followed by a base64url nonce, ciphertext and tag. The
+// clip page puts the body into an attribute for app.js to decrypt, so
+// nothing else gets through.
+func checkE2E(value string) error {
+	if !IsE2E(value) {
+		return nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, E2EPrefix))
+	if err != nil || len(sealed) < e2eNonceSize+e2eTagSize {
+		return errMalformedCiphertext
+	}
+	return nil
+}
+
+// EncryptE2E encrypts text with a new random key and returns the clip body
+// to store along with the key for the link fragment
+func EncryptE2E(plaintext string) (ciphertext, key string, err error) {
+	rawKey := randomBytes(32)
+	aead, err := e2eCipher(rawKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := randomBytes(aead.NonceSize())
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return E2EPrefix + base64.RawURLEncoding.EncodeToString(sealed), base64.RawURLEncoding.EncodeToString(rawKey), nil
+}
+
+// DecryptE2E decrypts an end-to-end encrypted clip body with the key from its link
+func DecryptE2E(ciphertext, key string) (string, error) {
+	if !IsE2E(ciphertext) {
+		return "", errors.New("clip is not end-to-end encrypted")
+	}
+
+	rawKey, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(rawKey) != 32 {
+		return "", errBadE2EKey
+	}
+	aead, err := e2eCipher(rawKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(ciphertext, E2EPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errMalformedCiphertext
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errBadE2EKey
+	}
+	return string(plaintext), nil
+}
+
+func e2eCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,53 @@
+package netclip_test
+
+import (
+	"strings"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestE2ERoundTrip(t *testing.T) {
+	ciphertext, key, err := netclip.EncryptE2E("top secret")
+	assert.NoError(t, err)
+	assert.True(t, netclip.IsE2E(ciphertext))
+	assert.NotContains(t, ciphertext, "top secret")
+
+	// Both parts have to be safe to put in a URL
+	assert.False(t, strings.ContainsAny(key, "+/="))
+	assert.False(t, strings.ContainsAny(strings.TrimPrefix(ciphertext, netclip.E2EPrefix), "+/="))
+
+	plaintext, err := netclip.DecryptE2E(ciphertext, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "top secret", plaintext)
+}
+
+func TestE2EWrongKey(t *testing.T) {
+	ciphertext, _, err := netclip.EncryptE2E("top secret")
+	assert.NoError(t, err)
+	_, otherKey, err := netclip.EncryptE2E("something else")
+	assert.NoError(t, err)
+
+	_, err = netclip.DecryptE2E(ciphertext, otherKey)
+	assert.Error(t, err)
+
+	_, err = netclip.DecryptE2E(ciphertext, "short")
+	assert.Error(t, err)
+}
+
+func TestE2EDecryptsBrowserFormat(t *testing.T) {
+	// Produced by encryptClip in static/app.js
+	ciphertext := "e2e:v1:AAECAwQFBgcICQoLL2e6d6rFpynoDsjcp_YOkCkjtAOA_suhgA"
+	key := "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+
+	plaintext, err := netclip.DecryptE2E(ciphertext, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello e2e", plaintext)
+}
+
+func TestIsE2E(t *testing.T) {
+	assert.True(t, netclip.IsE2E("e2e:v1:abc"))
+	assert.False(t, netclip.IsE2E("plain text"))
+}
@@ -0,0 +1,142 @@
+package netclip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EncryptionConfig holds the keys used to encrypt clips on disk
+type EncryptionConfig struct {
+	Key          string   `yaml:"key"`
+	KeyFile      string   `yaml:"key_file"`
+	PreviousKeys []string `yaml:"previous_keys"`
+}
+
+var (
+	errNoKey               = errors.New("clips are encrypted but no encryption key is configured")
+	errMalformedCiphertext = errors.New("malformed encrypted clip")
+)
+
+// Keyring encrypts clips with the current key and can decrypt clips written
+// with any previous key, so keys can be rotated.
+type Keyring struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from raw 32-byte AES-256 keys. The first key
+// is used for encryption.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %d is %d bytes, it must be 32", i+1, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyID(key)
+		if i == 0 {
+			kr.currentID = id
+		}
+		kr.keys[id] = aead
+	}
+
+	return kr, nil
+}
+
+// LoadKeyring builds a keyring from the config. The current key is the key
+// setting, or else the first line of the key file. Later lines and
+// previous_keys hold old keys. Keys are base64 encoded. It returns nil when
+// no key is configured. Use ApplyEnv to read NETCLIP_ENCRYPTION_KEY.
+func LoadKeyring(config EncryptionConfig) (*Keyring, error) {
+	var encoded []string
+
+	if config.Key != "" {
+		encoded = append(encoded, config.Key)
+	}
+
+	if config.KeyFile != "" {
+		data, err := readSecretFile(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				encoded = append(encoded, line)
+			}
+		}
+	}
+
+	encoded = append(encoded, config.PreviousKeys...)
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	keys := make([][]byte, len(encoded))
+	for i, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d is not valid base64: %w", i+1, err)
+		}
+		keys[i] = key
+	}
+
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Encrypt seals a clip body with the current key and returns the key's ID
+// along with the ciphertext. The clip's key is bound to the ciphertext so
+// bodies can't be swapped between clips.
+func (kr *Keyring) Encrypt(clipKey, plaintext string) (keyID, ciphertext string) {
+	aead := kr.keys[kr.currentID]
+
+	nonce := randomBytes(aead.NonceSize())
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(clipKey))
+
+	return kr.currentID, base64.StdEncoding.EncodeToString(sealed)
+}
+
+// Decrypt opens a clip body sealed with the key with the given ID. It also
+// reports whether the body was sealed with an old key and should be
+// re-encrypted.
+func (kr *Keyring) Decrypt(clipKey, keyID, ciphertext string) (plaintext string, stale bool, err error) {
+	aead, ok := kr.keys[keyID]
+	if !ok {
+		return "", false, fmt.Errorf("clip was encrypted with unknown key %s", keyID)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", false, errMalformedCiphertext
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	opened, err := aead.Open(nil, nonce, sealed, []byte(clipKey))
+	if err != nil {
+		return "", false, fmt.Errorf("could not decrypt clip: %w", err)
+	}
+
+	return string(opened), keyID != kr.currentID, nil
+}
@@ -0,0 +1,112 @@
+package netclip_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring, err := netclip.NewKeyring(testKey(1))
+	assert.NoError(t, err)
+
+	keyID, ciphertext := keyring.Encrypt("123", "secret text")
+	assert.NotContains(t, ciphertext, "secret text")
+
+	plaintext, stale, err := keyring.Decrypt("123", keyID, ciphertext)
+	assert.NoError(t, err)
+	assert.False(t, stale)
+	assert.Equal(t, "secret text", plaintext)
+}
+
+func TestKeyringBindsCiphertextToClipKey(t *testing.T) {
+	keyring, err := netclip.NewKeyring(testKey(1))
+	assert.NoError(t, err)
+
+	keyID, ciphertext := keyring.Encrypt("123", "secret text")
+
+	_, _, err = keyring.Decrypt("456", keyID, ciphertext)
+	assert.Error(t, err)
+}
+
+func TestKeyringDecryptsWithPreviousKeys(t *testing.T) {
+	oldKeyring, err := netclip.NewKeyring(testKey(1))
+	assert.NoError(t, err)
+	keyID, ciphertext := oldKeyring.Encrypt("123", "secret text")
+
+	keyring, err := netclip.NewKeyring(testKey(2), testKey(1))
+	assert.NoError(t, err)
+
+	plaintext, stale, err := keyring.Decrypt("123", keyID, ciphertext)
+	assert.NoError(t, err)
+	assert.True(t, stale)
+	assert.Equal(t, "secret text", plaintext)
+
+	// A keyring without the old key can't read it
+	newKeyring, err := netclip.NewKeyring(testKey(2))
+	assert.NoError(t, err)
+	_, _, err = newKeyring.Decrypt("123", keyID, ciphertext)
+	assert.Error(t, err)
+}
+
+func TestNewKeyringRejectsShortKeys(t *testing.T) {
+	_, err := netclip.NewKeyring([]byte("too short"))
+	assert.Error(t, err)
+}
+
+func TestLoadKeyringNoKeys(t *testing.T) {
+	keyring, err := netclip.LoadKeyring(netclip.EncryptionConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, keyring)
+}
+
+func TestLoadKeyringFromFile(t *testing.T) {
+	oldKeyring, err := netclip.NewKeyring(testKey(1))
+	assert.NoError(t, err)
+	keyID, ciphertext := oldKeyring.Encrypt("123", "secret text")
+
+	// The new key goes first, the old key stays below it until rotation is done
+	keyFile := filepath.Join(t.TempDir(), "netclip.key")
+	contents := "# netclip keys\n" +
+		base64.StdEncoding.EncodeToString(testKey(2)) + "\n" +
+		base64.StdEncoding.EncodeToString(testKey(1)) + "\n"
+	assert.NoError(t, os.WriteFile(keyFile, []byte(contents), 0600))
+
+	keyring, err := netclip.LoadKeyring(netclip.EncryptionConfig{KeyFile: keyFile})
+	assert.NoError(t, err)
+
+	plaintext, stale, err := keyring.Decrypt("123", keyID, ciphertext)
+	assert.NoError(t, err)
+	assert.True(t, stale)
+	assert.Equal(t, "secret text", plaintext)
+}
+
+func TestLoadKeyringFromEnv(t *testing.T) {
+	t.Setenv("NETCLIP_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(testKey(3)))
+
+	// The variable only counts once ApplyEnv has put it in the config
+	keyring, err := netclip.LoadKeyring(netclip.EncryptionConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, keyring)
+
+	config, err := netclip.ApplyEnv(netclip.Config{})
+	assert.NoError(t, err)
+	keyring, err = netclip.LoadKeyring(config.Encryption)
+	assert.NoError(t, err)
+	assert.NotNil(t, keyring)
+}
+
+func TestLoadKeyringInvalidBase64(t *testing.T) {
+	_, err := netclip.LoadKeyring(netclip.EncryptionConfig{Key: "not base64!"})
+	assert.Error(t, err)
+}
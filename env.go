@@ -0,0 +1,126 @@
+package netclip
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the name of every environment variable read into the
+// config
+const envPrefix = "NETCLIP_"
+
+// configField is one setting and its path in the config file, like
+// tailscale.hostname
+type configField struct {
+	path  string
+	field reflect.Value
+}
+
+// configFields lists the settings in a config struct. Nested sections are
+// flattened so every field is a single value or list.
+func configFields(v reflect.Value, prefix string) []configField {
+	var fields []configField
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		path := prefix + tag
+		if field := v.Field(i); field.Kind() == reflect.Struct {
+			fields = append(fields, configFields(field, path+".")...)
+		} else {
+			fields = append(fields, configField{path: path, field: field})
+		}
+	}
+	return fields
+}
+
+// envName is the environment variable for a setting, so tailscale.hostname
+// is NETCLIP_TAILSCALE_HOSTNAME
+func envName(path string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// ApplyEnv applies NETCLIP_* environment variables on top of the config
+// file. Flags are applied after, so they win over both. Lists are comma
+// separated, durations look like 10m, and listeners are given as YAML.
+// Empty variables are ignored.
+func ApplyEnv(config Config) (Config, error) {
+	for _, setting := range configFields(reflect.ValueOf(&config).Elem(), "") {
+		name := envName(setting.path)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if err := setField(setting.field, value); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return config, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setField parses an environment variable's value into a config field
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String {
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+		fallthrough
+	default:
+		// Anything more structured, like listeners, is YAML
+		fresh := reflect.New(field.Type())
+		decoder := yaml.NewDecoder(strings.NewReader(value))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(fresh.Interface()); err != nil {
+			return err
+		}
+		field.Set(fresh.Elem())
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package netclip_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("NETCLIP_PORT", "5000")
+	t.Setenv("NETCLIP_ADDRESSES", "127.0.0.1, ::1")
+	t.Setenv("NETCLIP_MODE", "read-only")
+	t.Setenv("NETCLIP_TAILSCALE_ENABLED", "true")
+	t.Setenv("NETCLIP_ACCESS_READ_ALLOW", "10.0.0.0/8,192.168.0.0/16")
+	t.Setenv("NETCLIP_RATE_LIMIT_WRITE_REQUESTS_PER_MINUTE", "30")
+	t.Setenv("NETCLIP_RATE_LIMIT_WRITE_BURST", "5")
+	t.Setenv("NETCLIP_SECRET_SCAN_FLAGGED_TTL", "10m")
+	t.Setenv("NETCLIP_LISTENERS", `[{port: "4001", mode: write-only}, {socket: /run/netclip.sock}]`)
+
+	config, err := netclip.ApplyEnv(netclip.Config{
+		Port:      "4000",
+		Tailscale: netclip.TailscaleConfig{Hostname: "clips"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "5000", config.Port)
+	assert.Equal(t, []string{"127.0.0.1", "::1"}, config.Addresses)
+	assert.Equal(t, netclip.ModeReadOnly, config.Mode)
+	assert.True(t, config.Tailscale.Enabled)
+	assert.Equal(t, "clips", config.Tailscale.Hostname)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, config.Access.Read.Allow)
+	assert.Equal(t, 30.0, config.RateLimit.Write.RequestsPerMinute)
+	assert.Equal(t, 5, config.RateLimit.Write.Burst)
+	assert.Equal(t, 10*time.Minute, config.SecretScan.FlaggedTTL)
+	assert.Equal(t, []netclip.ListenerConfig{
+		{Port: "4001", Mode: netclip.ModeWriteOnly},
+		{Socket: "/run/netclip.sock"},
+	}, config.Listeners)
+}
+
+func TestEnvPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netclip.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("port: \"4000\"\ncert_file: file.crt\nkey_file: file.key\n"), 0600))
+	t.Setenv("NETCLIP_PORT", "5000")
+	t.Setenv("NETCLIP_CERT_FILE", "env.crt")
+	t.Setenv("NETCLIP_KEY_FILE", "")
+
+	config, err := netclip.LoadConfig(path)
+	assert.NoError(t, err)
+	config, err = netclip.ApplyEnv(config)
+	assert.NoError(t, err)
+	config = netclip.ApplyFlags(config, netclip.Flags{Port: "6000"})
+
+	// Flags beat the environment, which beats the file
+	assert.Equal(t, "6000", config.Port)
+	assert.Equal(t, "env.crt", config.CertFile)
+	// An empty variable doesn't clear the file's setting
+	assert.Equal(t, "file.key", config.KeyFile)
+}
+
+func TestApplyEnvRejectsBadValues(t *testing.T) {
+	t.Setenv("NETCLIP_AUTO_TLS_ENABLED", "sometimes")
+
+	_, err := netclip.ApplyEnv(netclip.Config{})
+	assert.ErrorContains(t, err, "NETCLIP_AUTO_TLS_ENABLED")
+}
+
+func TestApplyEnvRejectsUnknownSettings(t *testing.T) {
+	t.Setenv("NETCLIP_LISTENERS", `[{prot: "4001"}]`)
+
+	_, err := netclip.ApplyEnv(netclip.Config{})
+	assert.ErrorContains(t, err, "NETCLIP_LISTENERS")
+	assert.ErrorContains(t, err, "field prot not found")
+}
@@ -0,0 +1,43 @@
+package netclip
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// exampleConfig is a config file with every section described and commented
+// out, for netclip config init
+//
+//go:embed netclip.example.yml
+var exampleConfig []byte
+
+// InitConfig writes a commented config file with the defaults to path,
+// creating its directory. An existing file is only replaced if overwrite is
+// set.
+func InitConfig(path string, overwrite bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(exampleConfig); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
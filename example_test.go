@@ -0,0 +1,54 @@
+package netclip_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "etc", "netclip.yml")
+	assert.NoError(t, netclip.InitConfig(path, false))
+
+	// The example is a valid config with the defaults
+	config, errs := netclip.CheckConfigFiles(path)
+	assert.Empty(t, errs)
+	assert.Equal(t, netclip.Config{Port: "9999"}, config)
+
+	// It won't replace a file unless told to
+	assert.NoError(t, os.WriteFile(path, []byte("port: \"4000\"\n"), 0644))
+	assert.ErrorContains(t, netclip.InitConfig(path, false), "already exists")
+	config, err := netclip.LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "4000", config.Port)
+
+	assert.NoError(t, netclip.InitConfig(path, true))
+	config, err = netclip.LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "9999", config.Port)
+}
+
+// exampleSetting matches the commented-out settings in the example config
+var exampleSetting = regexp.MustCompile(`(?m)^# ( *(?:- )?[a-z_]+:(?: .*)?| +- .*)$`)
+
+func TestExampleConfigSettingsAreKnown(t *testing.T) {
+	data, err := os.ReadFile("netclip.example.yml")
+	assert.NoError(t, err)
+
+	// Every example setting should load once uncommented
+	uncommented := exampleSetting.ReplaceAll(data, []byte("$1"))
+	path := filepath.Join(t.TempDir(), "netclip.yml")
+	assert.NoError(t, os.WriteFile(path, uncommented, 0644))
+
+	config, err := netclip.LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, netclip.ModeNormal, config.Mode)
+	assert.True(t, config.ACME.Enabled)
+	assert.Equal(t, 30.0, config.RateLimit.Write.RequestsPerMinute)
+	assert.Len(t, config.Listeners, 3)
+}
@@ -0,0 +1,70 @@
+package netclip
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/netip"
+
+	"tailscale.com/client/local"
+)
+
+type clientAddrKey struct{}
+
+type tailnetUserKey struct{}
+
+type socketPeerKey struct{}
+
+// isSocketPeer reports whether the request came in on a Unix socket
+func isSocketPeer(r *http.Request) bool {
+	peer, _ := r.Context().Value(socketPeerKey{}).(bool)
+	return peer
+}
+
+// withClientAddr records the client address resolved by the access policy
+func withClientAddr(r *http.Request, addr netip.Addr) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, addr))
+}
+
+// clientAddr returns the client address resolved by the access policy,
+// falling back to the connection's remote address.
+func clientAddr(r *http.Request) (netip.Addr, bool) {
+	if addr, ok := r.Context().Value(clientAddrKey{}).(netip.Addr); ok {
+		return addr, true
+	}
+	return remoteAddr(r)
+}
+
+// clientIdentity names the client for rate limiting and logging. On a tailnet
+// this is the Tailscale login, otherwise it's the client's IP address.
+func clientIdentity(r *http.Request) string {
+	if user, ok := r.Context().Value(tailnetUserKey{}).(string); ok && user != "" {
+		return user
+	}
+	if addr, ok := clientAddr(r); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// tailnetIdentity looks up who is on the other end of each tailnet connection
+// and records it on the request.
+func tailnetIdentity(lc *local.Client, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, err := lc.WhoIs(r.Context(), r.RemoteAddr)
+		if err != nil {
+			log.Printf("Could not identify tailnet client %s: %v", r.RemoteAddr, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := ""
+		if who.Node != nil && who.Node.IsTagged() {
+			user = who.Node.Name
+		} else if who.UserProfile != nil {
+			user = who.UserProfile.LoginName
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tailnetUserKey{}, user)))
+	})
+}
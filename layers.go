@@ -0,0 +1,203 @@
+package netclip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileContent is everything a config file can hold: the settings and
+// the other files it includes
+type configFileContent struct {
+	Include includeList `yaml:"include"`
+	Config  `yaml:",inline"`
+}
+
+// includeList takes a single path or a list of them
+type includeList []string
+
+func (l *includeList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = includeList{value.Value}
+		return nil
+	}
+	var paths []string
+	if err := value.Decode(&paths); err != nil {
+		return err
+	}
+	*l = paths
+	return nil
+}
+
+// configFile is one parsed config file
+type configFile struct {
+	path string
+	// root is the file's top-level mapping without include, or nil when
+	// the file is empty
+	root *yaml.Node
+}
+
+// configFileError is a file that isn't valid YAML or has settings netclip
+// doesn't know
+type configFileError struct {
+	path string
+	err  error
+}
+
+// Error names the file, line and setting for each problem, rather than the
+// YAML decoder's own wording
+func (e *configFileError) Error() string {
+	var messages []string
+	for _, err := range decodeErrors(e.path, e.err) {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e *configFileError) Unwrap() error {
+	return e.err
+}
+
+// decodeConfigFile parses a config file, rejecting settings netclip doesn't
+// know so typos don't go unnoticed
+func decodeConfigFile(data []byte) (configFileContent, error) {
+	var content configFileContent
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&content); err != nil && !errors.Is(err, io.EOF) {
+		return configFileContent{}, err
+	}
+	return content, nil
+}
+
+// readConfigFile parses a config file and the files it includes. Included
+// files come first, in the order they're listed, so the including file's
+// own settings win. Relative includes are relative to the including file,
+// and can be globs like conf.d/*.yml.
+func readConfigFile(path string, including []string) ([]configFile, error) {
+	path = filepath.Clean(path)
+	if slices.Contains(including, path) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(including, path), " -> "))
+	}
+	including = append(slices.Clip(including), path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := decodeConfigFile(data)
+	if err != nil {
+		return nil, &configFileError{path: path, err: err}
+	}
+
+	var files []configFile
+	for _, pattern := range content.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, &configFileError{path: path, err: err}
+			}
+		}
+		for _, match := range matches {
+			included, err := readConfigFile(match, including)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, included...)
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &configFileError{path: path, err: err}
+	}
+	file := configFile{path: path}
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		file.root = doc.Content[0]
+		removeKey(file.root, "include")
+	}
+	return append(files, file), nil
+}
+
+// readConfigFiles reads each file and its includes, in order
+func readConfigFiles(paths []string) ([]configFile, error) {
+	var files []configFile
+	for _, path := range paths {
+		found, err := readConfigFile(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+func removeKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+			return
+		}
+	}
+}
+
+// mergeNodes applies the settings in src on top of dst. Sections are merged
+// setting by setting, while values and lists replace what was there.
+func mergeNodes(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		found := false
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value != key.Value {
+				continue
+			}
+			if existing := dst.Content[j+1]; existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+				mergeNodes(existing, value)
+			} else {
+				dst.Content[j+1] = value
+			}
+			found = true
+			break
+		}
+		if !found {
+			dst.Content = append(dst.Content, key, value)
+		}
+	}
+}
+
+// mergeConfigFiles combines files into one config, later files winning
+func mergeConfigFiles(files []configFile) (Config, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, file := range files {
+		if file.root != nil {
+			mergeNodes(merged, file.root)
+		}
+	}
+
+	var config Config
+	if err := merged.Decode(&config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// LoadConfigs loads config files in order of precedence, lowest first, so
+// each file only needs the settings it changes from the ones before it
+func LoadConfigs(paths ...string) (Config, error) {
+	files, err := readConfigFiles(paths)
+	if err != nil {
+		return Config{}, err
+	}
+	return mergeConfigFiles(files)
+}
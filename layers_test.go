@@ -0,0 +1,123 @@
+package netclip_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func TestLoadConfigsMerges(t *testing.T) {
+	dir := t.TempDir()
+	system := filepath.Join(dir, "etc", "netclip.yml")
+	user := filepath.Join(dir, "home", "netclip.yml")
+	writeFile(t, system, `port: "4000"
+mode: read-only
+tailscale:
+  enabled: true
+  hostname: office
+access:
+  write:
+    allow: ["10.0.0.0/8", "192.168.0.0/16"]
+`)
+	writeFile(t, user, `port: "5000"
+tailscale:
+  hostname: laptop
+access:
+  write:
+    allow: ["192.168.1.0/24"]
+`)
+
+	config, err := netclip.LoadConfigs(system, user)
+	assert.NoError(t, err)
+	assert.Equal(t, "5000", config.Port)
+	assert.Equal(t, netclip.ModeReadOnly, config.Mode)
+	assert.True(t, config.Tailscale.Enabled)
+	assert.Equal(t, "laptop", config.Tailscale.Hostname)
+	// Lists are replaced, not appended to
+	assert.Equal(t, []string{"192.168.1.0/24"}, config.Access.Write.Allow)
+
+	// Precedence follows the order of the files
+	config, err = netclip.LoadConfigs(user, system)
+	assert.NoError(t, err)
+	assert.Equal(t, "4000", config.Port)
+	assert.Equal(t, "office", config.Tailscale.Hostname)
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "base.yml"), `port: "4000"
+mode: read-only
+data_file: /var/lib/netclip/clips.json
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "10-tailscale.yml"), `tailscale:
+  enabled: true
+mode: write-only
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "20-port.yml"), `port: "4500"
+`)
+	main := filepath.Join(dir, "netclip.yml")
+	writeFile(t, main, `include:
+  - base.yml
+  - conf.d/*.yml
+port: "5000"
+`)
+
+	config, err := netclip.LoadConfig(main)
+	assert.NoError(t, err)
+	// The including file wins, then later includes over earlier ones
+	assert.Equal(t, "5000", config.Port)
+	assert.Equal(t, netclip.ModeWriteOnly, config.Mode)
+	assert.Equal(t, "/var/lib/netclip/clips.json", config.DataFile)
+	assert.True(t, config.Tailscale.Enabled)
+
+	// A single include doesn't need a list
+	single := filepath.Join(dir, "single.yml")
+	writeFile(t, single, "include: base.yml\n")
+	config, err = netclip.LoadConfig(single)
+	assert.NoError(t, err)
+	assert.Equal(t, "4000", config.Port)
+}
+
+func TestLoadConfigIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.yml")
+	writeFile(t, a, "include: b.yml\n")
+	writeFile(t, filepath.Join(dir, "b.yml"), "include: a.yml\n")
+
+	_, err := netclip.LoadConfig(a)
+	assert.ErrorContains(t, err, "include cycle")
+
+	missing := filepath.Join(dir, "missing.yml")
+	writeFile(t, missing, "include: nowhere.yml\n")
+	_, err = netclip.LoadConfig(missing)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCheckConfigFilesPointsAtFile(t *testing.T) {
+	dir := t.TempDir()
+	system := filepath.Join(dir, "system.yml")
+	user := filepath.Join(dir, "user.yml")
+	writeFile(t, system, "port: \"4000\"\nmode: sideways\n")
+	writeFile(t, user, "port: \"99999\"\n")
+
+	_, errs := netclip.CheckConfigFiles(system, user)
+	assert.Equal(t, []string{
+		user + `:1: port: "99999" is not a port number from 1 to 65535`,
+		system + `:2: mode: unknown mode "sideways", use normal, read-only or write-only`,
+	}, errorStrings(errs))
+
+	included := filepath.Join(dir, "included.yml")
+	writeFile(t, included, "port: \"4000\"\nmdoe: normal\n")
+	writeFile(t, user, "include: included.yml\n")
+	_, errs = netclip.CheckConfigFiles(user)
+	assert.Equal(t, []string{included + ":2: unknown setting mdoe"}, errorStrings(errs))
+}
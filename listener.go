@@ -0,0 +1,233 @@
+package netclip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// ListenerConfig is one place netclip serves clips. Listeners share the
+// app's clips and audit log but each has its own TLS, access rules and mode.
+type ListenerConfig struct {
+	Name string `yaml:"name"`
+	// Tailscale serves on the tailnet with the tailscale settings instead
+	// of on a port
+	Tailscale bool `yaml:"tailscale"`
+	// Socket serves on a Unix domain socket at this path instead of on a
+	// port. SocketMode sets its permissions in octal, like "0660".
+	Socket     string `yaml:"socket"`
+	SocketMode string `yaml:"socket_mode"`
+	Port       string `yaml:"port"`
+	// Addresses to listen on, falling back to the top-level addresses
+	Addresses []string `yaml:"addresses"`
+	CertFile  string   `yaml:"cert_file"`
+	KeyFile   string   `yaml:"key_file"`
+	// ACME gets this listener's certificates with the acme settings
+	ACME bool `yaml:"acme"`
+	// Mode and Access fall back to the top-level settings when left out
+	Mode   Mode          `yaml:"mode"`
+	Access *AccessConfig `yaml:"access"`
+}
+
+// String names the listener in logs and errors
+func (l ListenerConfig) String() string {
+	switch {
+	case l.Name != "":
+		return l.Name
+	case l.Tailscale:
+		return "tailnet"
+	case l.Socket != "":
+		return "socket " + l.Socket
+	default:
+		return "port " + l.Port
+	}
+}
+
+// socketMode parses the socket's octal permissions
+func (l ListenerConfig) socketMode() (os.FileMode, error) {
+	if l.SocketMode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("socket_mode %q is not octal permissions like 0660", l.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// validateListeners checks that the listeners can all be started together
+func validateListeners(listeners []ListenerConfig, acme ACMEConfig) error {
+	var tailnet, acmeListeners int
+	for _, l := range listeners {
+		if err := l.Mode.Validate(); err != nil {
+			return fmt.Errorf("listener %s: %w", l, err)
+		}
+
+		if l.Tailscale && l.Socket != "" {
+			return fmt.Errorf("listener %s: choose either tailscale or socket", l)
+		}
+		if l.SocketMode != "" {
+			if l.Socket == "" {
+				return fmt.Errorf("listener %s: socket_mode needs a socket", l)
+			}
+			if _, err := l.socketMode(); err != nil {
+				return fmt.Errorf("listener %s: %w", l, err)
+			}
+		}
+
+		if l.ACME {
+			acmeListeners++
+			if !acme.Enabled {
+				return fmt.Errorf("listener %s: acme needs the acme section enabled", l)
+			}
+			if l.CertFile != "" || l.KeyFile != "" {
+				return fmt.Errorf("listener %s: choose either acme or cert_file and key_file", l)
+			}
+		}
+
+		if l.Socket != "" {
+			if l.Port != "" || len(l.Addresses) > 0 || l.CertFile != "" || l.KeyFile != "" || l.ACME {
+				return fmt.Errorf("listener %s: socket listeners don't use port, addresses, cert_file, key_file or acme", l)
+			}
+			continue
+		}
+
+		if l.Tailscale {
+			tailnet++
+			if l.Port != "" || len(l.Addresses) > 0 || l.CertFile != "" || l.KeyFile != "" || l.ACME {
+				return fmt.Errorf("listener %s: tailnet listeners use the tailscale settings, not port, addresses, cert_file, key_file or acme", l)
+			}
+			continue
+		}
+
+		if l.Port == "" {
+			return fmt.Errorf("listener %s: port is required", l)
+		}
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			return fmt.Errorf("listener %s: cert_file and key_file must be set together", l)
+		}
+	}
+
+	if tailnet > 1 {
+		return errors.New("only one listener can be on the tailnet")
+	}
+	if acmeListeners > 1 {
+		return errors.New("only one listener can use acme")
+	}
+	return nil
+}
+
+// UsesTailscale reports whether the config puts netclip on the tailnet,
+// through a tailnet listener or, without listeners, tailscale.enabled
+func (c Config) UsesTailscale() bool {
+	if len(c.Listeners) == 0 {
+		return c.Tailscale.Enabled
+	}
+	return slices.ContainsFunc(c.Listeners, func(l ListenerConfig) bool { return l.Tailscale })
+}
+
+// createListenerServer creates the server for a listener. Tailnet listeners
+// take their settings from the tailscale section.
+func createListenerServer(l ListenerConfig, config Config, authKey string) Server {
+	if l.Socket != "" {
+		// validateListeners has already checked the mode
+		mode, _ := l.socketMode()
+		return &UnixServer{Path: l.Socket, Mode: mode}
+	}
+	if l.Tailscale {
+		return newTSNetServer(config.Tailscale, authKey)
+	}
+	addresses := l.Addresses
+	if len(addresses) == 0 {
+		addresses = config.Addresses
+	}
+	server := &HTTPServer{
+		Addresses: addresses,
+		Port:      l.Port,
+		CertFile:  l.CertFile,
+		KeyFile:   l.KeyFile,
+	}
+	if l.ACME {
+		server.ACME = config.ACME
+	}
+	return server
+}
+
+// multiListener accepts connections from several listeners, so a server can
+// listen on more than one address
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	ml := &multiListener{
+		listeners: listeners,
+		conns:     make(chan acceptResult),
+		closed:    make(chan struct{}),
+	}
+	for _, ln := range listeners {
+		go ml.accept(ln)
+	}
+	return ml
+}
+
+func (ml *multiListener) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		select {
+		case ml.conns <- acceptResult{conn, err}:
+		case <-ml.closed:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		// Temporary errors, like running out of file descriptors, are passed
+		// on for http.Server to back off from, and then we accept again
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Temporary()) {
+			return
+		}
+	}
+}
+
+// Accept waits for a connection on any of the listeners
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-ml.conns:
+		return result.conn, result.err
+	case <-ml.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops all the listeners
+func (ml *multiListener) Close() error {
+	var err error
+	ml.closeOnce.Do(func() {
+		close(ml.closed)
+		for _, ln := range ml.listeners {
+			if closeErr := ln.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the first listener's address
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
@@ -0,0 +1,136 @@
+package netclip_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// freePort finds a port nothing is listening on
+func freePort(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func TestNewAppRejectsBadListeners(t *testing.T) {
+	for name, listeners := range map[string][]netclip.ListenerConfig{
+		"no port":          {{Name: "office"}},
+		"cert without key": {{Port: "9999", CertFile: "netclip.crt"}},
+		"two tailnets":     {{Tailscale: true}, {Tailscale: true}},
+		"port on tailnet":  {{Tailscale: true, Port: "80"}},
+		"unknown mode":     {{Port: "9999", Mode: "sideways"}},
+		"socket and port":  {{Socket: "/run/netclip.sock", Port: "9999"}},
+		"bad socket mode":  {{Socket: "/run/netclip.sock", SocketMode: "rw-rw----"}},
+		"mode, no socket":  {{Port: "9999", SocketMode: "0660"}},
+		"bad access rule":  {{Port: "9999", Access: &netclip.AccessConfig{Read: netclip.AccessRule{Allow: []string{"nowhere"}}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := netclip.NewApp(netclip.Config{Listeners: listeners})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestConfigUsesTailscale(t *testing.T) {
+	assert.False(t, netclip.Config{}.UsesTailscale())
+	assert.True(t, netclip.Config{Tailscale: netclip.TailscaleConfig{Enabled: true}}.UsesTailscale())
+
+	// Listeners replace the top-level setting
+	assert.False(t, netclip.Config{
+		Tailscale: netclip.TailscaleConfig{Enabled: true},
+		Listeners: []netclip.ListenerConfig{{Port: "9999"}},
+	}.UsesTailscale())
+	assert.True(t, netclip.Config{
+		Listeners: []netclip.ListenerConfig{{Port: "9999"}, {Tailscale: true}},
+	}.UsesTailscale())
+}
+
+func TestRunListenersSharesClips(t *testing.T) {
+	officePort, wallPort := freePort(t), freePort(t)
+
+	app, err := netclip.NewApp(netclip.Config{
+		Listeners: []netclip.ListenerConfig{
+			{Name: "office", Port: officePort},
+			{Name: "wall", Port: wallPort, Mode: netclip.ModeReadOnly},
+		},
+	})
+	assert.NoError(t, err)
+	defer app.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.RunListeners(ctx, "")
+	}()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	office := &netclip.Client{BaseURL: "http://127.0.0.1:" + officePort}
+	wall := &netclip.Client{BaseURL: "http://127.0.0.1:" + wallPort}
+
+	var link string
+	assert.Eventually(t, func() bool {
+		link, err = office.Paste("on every screen", false)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The read-only listener shows the office's clip but won't take new ones
+	text, err := wall.Get(strings.Replace(link, officePort, wallPort, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, "on every screen", text)
+
+	_, err = wall.Paste("from the wall", false)
+	assert.ErrorContains(t, err, http.StatusText(http.StatusForbidden))
+}
+
+func TestRunListenersDoesNotWaitForTailnet(t *testing.T) {
+	control := startControl(t)
+	// Without an auth key the node waits for a login that never comes
+	control.RequireAuth = true
+
+	port := freePort(t)
+	app, err := netclip.NewApp(netclip.Config{
+		Tailscale: netclip.TailscaleConfig{
+			StateDir:   t.TempDir(),
+			ControlURL: control.HTTPTestServer.URL,
+			Tags:       []string{"tag:netclip"},
+		},
+		Listeners: []netclip.ListenerConfig{{Tailscale: true}, {Port: port}},
+	})
+	assert.NoError(t, err)
+	defer app.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.RunListeners(ctx, "")
+	}()
+
+	// The office listener serves while the tailnet one is still waiting
+	office := &netclip.Client{BaseURL: "http://127.0.0.1:" + port}
+	assert.Eventually(t, func() bool {
+		_, err := office.Paste("before the tailnet", false)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Stopping doesn't wait for the login either
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("RunListeners is still waiting for the tailnet")
+	}
+}
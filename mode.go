@@ -0,0 +1,73 @@
+package netclip
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Mode limits what a server lets people do
+type Mode string
+
+// Server modes. A read-only server shows clips but won't save or delete
+// them, like a wall display. A write-only server takes new clips but never
+// shows them, like a drop box.
+const (
+	ModeNormal    Mode = "normal"
+	ModeReadOnly  Mode = "read-only"
+	ModeWriteOnly Mode = "write-only"
+)
+
+// Validate checks that the mode is one netclip knows. Empty means normal.
+func (m Mode) Validate() error {
+	switch m {
+	case "", ModeNormal, ModeReadOnly, ModeWriteOnly:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q, use %s, %s or %s", string(m), ModeNormal, ModeReadOnly, ModeWriteOnly)
+}
+
+// CanRead reports whether clips can be listed and viewed
+func (m Mode) CanRead() bool {
+	return m != ModeWriteOnly
+}
+
+// CanSave reports whether new clips can be saved
+func (m Mode) CanSave() bool {
+	return m != ModeReadOnly
+}
+
+// CanDelete reports whether clips can be deleted. Deleting only makes sense
+// when you can see what you're deleting.
+func (m Mode) CanDelete() bool {
+	return m != ModeReadOnly && m != ModeWriteOnly
+}
+
+type modeKey struct{}
+
+// withMode serves requests under a mode, so one app can be read-only on one
+// listener and writable on another
+func withMode(mode Mode, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), modeKey{}, mode)))
+	})
+}
+
+// requestMode returns the mode the request is served under, or fallback
+// when it didn't come through withMode
+func requestMode(r *http.Request, fallback Mode) Mode {
+	if mode, ok := r.Context().Value(modeKey{}).(Mode); ok {
+		return mode
+	}
+	return fallback
+}
+
+// modeAllows responds with 403 Forbidden when the server's mode turns off
+// what the request wants to do, such as Mode.CanSave
+func modeAllows(w http.ResponseWriter, mode Mode, can func(Mode) bool) bool {
+	if !can(mode) {
+		http.Error(w, fmt.Sprintf("Forbidden: this server is %s", mode), http.StatusForbidden)
+		return false
+	}
+	return true
+}
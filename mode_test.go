@@ -0,0 +1,149 @@
+package netclip_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModeValidate(t *testing.T) {
+	assert.NoError(t, netclip.Mode("").Validate())
+	assert.NoError(t, netclip.ModeNormal.Validate())
+	assert.NoError(t, netclip.ModeReadOnly.Validate())
+	assert.NoError(t, netclip.ModeWriteOnly.Validate())
+	assert.Error(t, netclip.Mode("kiosk").Validate())
+}
+
+func TestModePermissions(t *testing.T) {
+	for _, mode := range []netclip.Mode{"", netclip.ModeNormal} {
+		assert.True(t, mode.CanRead())
+		assert.True(t, mode.CanSave())
+		assert.True(t, mode.CanDelete())
+	}
+
+	// Wall display
+	assert.True(t, netclip.ModeReadOnly.CanRead())
+	assert.False(t, netclip.ModeReadOnly.CanSave())
+	assert.False(t, netclip.ModeReadOnly.CanDelete())
+
+	// Drop box
+	assert.False(t, netclip.ModeWriteOnly.CanRead())
+	assert.True(t, netclip.ModeWriteOnly.CanSave())
+	assert.False(t, netclip.ModeWriteOnly.CanDelete())
+}
+
+// saveClipTo saves a clip through the API of a normal app backed by
+// dataFile and returns its key
+func saveClipTo(t *testing.T, dataFile, text string) string {
+	app, err := netclip.NewApp(netclip.Config{DataFile: dataFile})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/clips", strings.NewReader(`{"text": "`+text+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.NoError(t, app.Close())
+	return created.Key
+}
+
+func TestReadOnlyHandlers(t *testing.T) {
+	dataFile := filepath.Join(t.TempDir(), "clips.json")
+	key := saveClipTo(t, dataFile, "on the wall")
+
+	app, err := netclip.NewApp(netclip.Config{Mode: netclip.ModeReadOnly, DataFile: dataFile})
+	assert.NoError(t, err)
+	defer app.Close()
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "on the wall")
+	assert.NotContains(t, rr.Body.String(), `action="save"`)
+	assert.NotContains(t, rr.Body.String(), "Delete this clip")
+
+	for _, path := range []string{"/raw/" + key, "/clip/" + key} {
+		rr = httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+
+	forms := map[string]url.Values{
+		"/save":   {"text": {"not allowed"}},
+		"/delete": {"key": {key}},
+	}
+	for path, form := range forms {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code, path)
+		assert.Contains(t, rr.Body.String(), "this server is read-only", path)
+	}
+
+	req := httptest.NewRequest("POST", "/api/clips", strings.NewReader(`{"text": "not allowed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// The clip is still there
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/raw/"+key, nil))
+	assert.Equal(t, "on the wall", rr.Body.String())
+}
+
+func TestWriteOnlyHandlers(t *testing.T) {
+	app, err := netclip.NewApp(netclip.Config{Mode: netclip.ModeWriteOnly})
+	assert.NoError(t, err)
+	defer app.Close()
+
+	req := httptest.NewRequest("POST", "/api/clips", strings.NewReader(`{"text": "into the box"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	// There's no link to a clip that can't be viewed
+	assert.NotContains(t, rr.Body.String(), `"url"`)
+
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `action="save"`)
+	assert.Contains(t, rr.Body.String(), "Clips saved here aren't listed")
+	assert.NotContains(t, rr.Body.String(), "Saved clips")
+	assert.NotContains(t, rr.Body.String(), "into the box")
+	assert.NotContains(t, rr.Body.String(), "Delete this clip")
+
+	for _, path := range []string{"/raw/" + created.Key, "/clip/" + created.Key} {
+		rr = httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusForbidden, rr.Code, path)
+		assert.NotContains(t, rr.Body.String(), "into the box", path)
+	}
+
+	req = httptest.NewRequest("POST", "/delete", strings.NewReader(url.Values{"key": {created.Key}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "this server is write-only")
+}
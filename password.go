@@ -0,0 +1,113 @@
+package netclip
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id settings for clip passphrases. These follow the OWASP minimum so
+// a check stays quick on small machines while guessing stays expensive.
+const (
+	argonTime    = 2
+	argonMemory  = 19 * 1024
+	argonThreads = 1
+	argonKeyLen  = 32
+)
+
+// defaultPassphraseChecks is how many passphrases an app checks at once
+// unless max_passphrase_checks says otherwise. Each check takes argonMemory
+// KiB, so this caps the memory that requests for protected clips can use
+// whatever the rate limits are.
+const defaultPassphraseChecks = 4
+
+// passphraseHeader lets API clients send a clip's passphrase without HTTP
+// basic authentication
+const passphraseHeader = "X-Clip-Passphrase"
+
+// HashPassphrase hashes a clip passphrase with Argon2id. The result holds
+// the parameters and salt in the usual $argon2id$ form.
+func HashPassphrase(passphrase string) string {
+	salt := randomBytes(16)
+	hash := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash))
+}
+
+// CheckPassphrase reports whether the passphrase matches a hash from HashPassphrase
+func CheckPassphrase(encoded, passphrase string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	got := argon2.IDKey([]byte(passphrase), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// requestPassphrase pulls a clip passphrase from the request. Browsers send
+// it with HTTP basic authentication, where the user name is ignored.
+func requestPassphrase(r *http.Request) string {
+	if passphrase := r.Header.Get(passphraseHeader); passphrase != "" {
+		return passphrase
+	}
+	if _, passphrase, ok := r.BasicAuth(); ok {
+		return passphrase
+	}
+	return ""
+}
+
+// unlockClip checks the passphrase for a protected clip. If it's missing or
+// wrong, it asks the browser for one and returns false.
+func (a *App) unlockClip(w http.ResponseWriter, r *http.Request, key string, clip Clip) bool {
+	if !clip.Protected() {
+		return true
+	}
+
+	passphrase := requestPassphrase(r)
+	if passphrase != "" {
+		select {
+		case a.passphraseChecks <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many passphrase checks, try again shortly", http.StatusServiceUnavailable)
+			return false
+		}
+		ok := CheckPassphrase(clip.PasswordHash, passphrase)
+		<-a.passphraseChecks
+		if ok {
+			return true
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="netclip clip %s", charset="UTF-8"`, key))
+	http.Error(w, "This clip is protected by a passphrase", http.StatusUnauthorized)
+	return false
+}
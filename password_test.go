@@ -0,0 +1,30 @@
+package netclip_test
+
+import (
+	"strings"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashPassphrase(t *testing.T) {
+	hash := netclip.HashPassphrase("correct horse")
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$"))
+	assert.NotContains(t, hash, "correct horse")
+
+	assert.True(t, netclip.CheckPassphrase(hash, "correct horse"))
+	assert.False(t, netclip.CheckPassphrase(hash, "battery staple"))
+	assert.False(t, netclip.CheckPassphrase(hash, ""))
+}
+
+func TestHashPassphraseIsSalted(t *testing.T) {
+	assert.NotEqual(t, netclip.HashPassphrase("same"), netclip.HashPassphrase("same"))
+}
+
+func TestCheckPassphraseMalformedHash(t *testing.T) {
+	assert.False(t, netclip.CheckPassphrase("", "anything"))
+	assert.False(t, netclip.CheckPassphrase("$2a$10$notargon", "anything"))
+	assert.False(t, netclip.CheckPassphrase("$argon2id$v=19$m=19456,t=2,p=1$!!!$!!!", "anything"))
+}
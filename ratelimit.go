@@ -0,0 +1,117 @@
+package netclip
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig holds separate request limits for reads and writes
+type RateLimitConfig struct {
+	Read  RateLimit `yaml:"read"`
+	Write RateLimit `yaml:"write"`
+}
+
+// RateLimit is a token bucket per client. A zero RequestsPerMinute means no limit.
+type RateLimit struct {
+	RequestsPerMinute float64 `yaml:"requests_per_minute"`
+	Burst             int     `yaml:"burst"`
+}
+
+// idleLimiterTTL is how long a client's bucket is kept after its last request
+const idleLimiterTTL = 10 * time.Minute
+
+// RateLimiter tracks a token bucket for each client
+type RateLimiter struct {
+	config    RateLimitConfig
+	mu        sync.Mutex
+	buckets   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter creates a rate limiter with the given limits
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:    config,
+		buckets:   make(map[string]*clientBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve takes a token from the client's bucket for this kind of request.
+// It returns zero when the request may go ahead, or how long the client
+// has to wait before trying again.
+func (rl *RateLimiter) Reserve(client string, write bool) time.Duration {
+	limit := rl.config.Read
+	key := "read:" + client
+	if write {
+		limit = rl.config.Write
+		key = "write:" + client
+	}
+	if limit.RequestsPerMinute <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.sweep(now)
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		bucket = &clientBucket{
+			limiter: rate.NewLimiter(rate.Limit(limit.RequestsPerMinute/60), burst),
+		}
+		rl.buckets[key] = bucket
+	}
+	bucket.lastSeen = now
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// sweep drops buckets for clients that have gone quiet so the map doesn't
+// grow forever. Callers must hold rl.mu.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.lastSeen) > idleLimiterTTL {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// Middleware answers with 429 Too Many Requests when a client runs out of tokens
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay := rl.Reserve(clientIdentity(r), !isReadRequest(r))
+		if delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package netclip_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterUnlimitedByDefault(t *testing.T) {
+	limiter := netclip.NewRateLimiter(netclip.RateLimitConfig{})
+
+	for i := 0; i < 100; i++ {
+		assert.Zero(t, limiter.Reserve("192.168.1.20", true))
+	}
+}
+
+func TestRateLimiterBurstThenWait(t *testing.T) {
+	limiter := netclip.NewRateLimiter(netclip.RateLimitConfig{
+		Write: netclip.RateLimit{RequestsPerMinute: 6, Burst: 2},
+	})
+
+	assert.Zero(t, limiter.Reserve("192.168.1.20", true))
+	assert.Zero(t, limiter.Reserve("192.168.1.20", true))
+
+	// Six a minute means a new token every ten seconds
+	delay := limiter.Reserve("192.168.1.20", true)
+	assert.Greater(t, delay.Seconds(), 9.0)
+	assert.LessOrEqual(t, delay.Seconds(), 10.0)
+
+	// Rejected requests don't use up tokens
+	assert.InDelta(t, delay.Seconds(), limiter.Reserve("192.168.1.20", true).Seconds(), 0.5)
+}
+
+func TestRateLimiterSeparatesClientsAndKinds(t *testing.T) {
+	limiter := netclip.NewRateLimiter(netclip.RateLimitConfig{
+		Read:  netclip.RateLimit{RequestsPerMinute: 60, Burst: 1},
+		Write: netclip.RateLimit{RequestsPerMinute: 1, Burst: 1},
+	})
+
+	assert.Zero(t, limiter.Reserve("alice@example.com", true))
+	assert.NotZero(t, limiter.Reserve("alice@example.com", true))
+
+	// Reads have their own bucket, and other clients aren't affected
+	assert.Zero(t, limiter.Reserve("alice@example.com", false))
+	assert.Zero(t, limiter.Reserve("bob@example.com", true))
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	limiter := netclip.NewRateLimiter(netclip.RateLimitConfig{
+		Write: netclip.RateLimit{RequestsPerMinute: 2, Burst: 1},
+	})
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest(t, "POST", "192.168.1.20:1234"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest(t, "POST", "192.168.1.20:5678"))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+
+	// Reads are unlimited here
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest(t, "GET", "192.168.1.20:1234"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func TestRateLimitSkipsStaticFiles(t *testing.T) {
+	app, err := netclip.NewApp(netclip.Config{
+		RateLimit: netclip.RateLimitConfig{
+			Read: netclip.RateLimit{RequestsPerMinute: 1, Burst: 1},
+		},
+	})
+	assert.NoError(t, err)
+	defer app.Close()
+
+	for _, path := range []string{"/", "/static/app.css", "/static/app.js"} {
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+}
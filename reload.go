@@ -0,0 +1,182 @@
+package netclip
+
+import (
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ConfigCheckInterval is how often WatchConfig looks for changes to the
+// config file
+var ConfigCheckInterval = 2 * time.Second
+
+// liveHandler serves with whichever handler was set last, so a reload can
+// change the handler behind a running server
+type liveHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func (h *liveHandler) set(handler http.Handler) {
+	h.current.Store(&handler)
+}
+
+func (h *liveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.current.Load()).ServeHTTP(w, r)
+}
+
+// restartSettings are the settings a running app can't change. They're
+// used when servers start, clips load or the audit log opens.
+var restartSettings = []struct {
+	name  string
+	field func(*Config) any
+}{
+	{"port", func(c *Config) any { return &c.Port }},
+	{"addresses", func(c *Config) any { return &c.Addresses }},
+	{"cert_file", func(c *Config) any { return &c.CertFile }},
+	{"key_file", func(c *Config) any { return &c.KeyFile }},
+	{"auto_tls", func(c *Config) any { return &c.AutoTLS }},
+	{"acme", func(c *Config) any { return &c.ACME }},
+	{"tailscale", func(c *Config) any { return &c.Tailscale }},
+	{"data_file", func(c *Config) any { return &c.DataFile }},
+	{"encryption", func(c *Config) any { return &c.Encryption }},
+	{"audit", func(c *Config) any { return &c.Audit }},
+	{"max_passphrase_checks", func(c *Config) any { return &c.MaxPassphraseChecks }},
+}
+
+// listenersChanged reports whether listeners were added, removed or moved.
+// Their modes and access rules can change while running.
+func listenersChanged(old, updated []ListenerConfig) bool {
+	if len(old) != len(updated) {
+		return true
+	}
+	for i := range old {
+		a, b := old[i], updated[i]
+		a.Mode, a.Access = "", nil
+		b.Mode, b.Access = "", nil
+		if !reflect.DeepEqual(a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload applies a new config to the running app. Access rules, rate
+// limits, modes and secret scanning change right away. Settings that need a
+// restart keep their running values and are returned by name. If the new
+// config isn't valid, nothing changes.
+func (a *App) Reload(config Config) ([]string, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	running := a.settings()
+	if a.autoCerts != nil && reflect.DeepEqual(config.AutoTLS, running.AutoTLS) {
+		config = useAutoCerts(config, a.autoCerts)
+	}
+
+	var restart []string
+	for _, setting := range restartSettings {
+		kept, updated := setting.field(&running), setting.field(&config)
+		if !reflect.DeepEqual(kept, updated) {
+			restart = append(restart, setting.name)
+			reflect.ValueOf(updated).Elem().Set(reflect.ValueOf(kept).Elem())
+		}
+	}
+	if listenersChanged(running.Listeners, config.Listeners) {
+		restart = append(restart, "listeners")
+		config.Listeners = running.Listeners
+	}
+
+	a.mu.RLock()
+	limiter := a.limiter
+	a.mu.RUnlock()
+	if !reflect.DeepEqual(config.RateLimit, running.RateLimit) {
+		limiter = NewRateLimiter(config.RateLimit)
+	}
+
+	handler, listenerHandlers, err := a.newHandlers(config, limiter)
+	if err != nil {
+		return nil, err
+	}
+	a.apply(config, limiter, handler, listenerHandlers)
+	return restart, nil
+}
+
+// WatchConfig reloads the app's config with load whenever one of the files
+// at paths or the files they include changes, or a signal arrives on
+// reload, until ctx is done.
+// Problems and settings that need a restart are logged.
+func (a *App) WatchConfig(ctx context.Context, paths []string, reload <-chan os.Signal, load func() (Config, error)) {
+	// The files are found again on every check, so included files are
+	// watched too, along with new files that match an include glob
+	stamps := func() map[string]time.Time {
+		modified := make(map[string]time.Time)
+		for _, path := range watchedConfigFiles(paths) {
+			var stamp time.Time
+			if info, err := os.Stat(path); err == nil {
+				stamp = info.ModTime()
+			}
+			modified[path] = stamp
+		}
+		return modified
+	}
+	modified := stamps()
+
+	ticker := time.NewTicker(ConfigCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if latest := stamps(); !maps.EqualFunc(latest, modified, time.Time.Equal) {
+				modified = latest
+				a.reloadFrom(paths, load)
+			}
+		case <-reload:
+			modified = stamps()
+			a.reloadFrom(paths, load)
+		}
+	}
+}
+
+// watchedConfigFiles returns the config files at paths and every file they
+// include. If they can't be read, it's just paths, until they're fixed.
+func watchedConfigFiles(paths []string) []string {
+	files, err := readConfigFiles(paths)
+	if err != nil {
+		return paths
+	}
+	watched := make([]string, len(files))
+	for i, file := range files {
+		watched[i] = file.path
+	}
+	return watched
+}
+
+func (a *App) reloadFrom(paths []string, load func() (Config, error)) {
+	path := strings.Join(paths, ", ")
+	config, err := load()
+	if err != nil {
+		log.Printf("Could not reload %s, keeping the running config: %v", path, err)
+		return
+	}
+
+	restart, err := a.Reload(config)
+	if err != nil {
+		log.Printf("Could not apply %s, keeping the running config: %v", path, err)
+		return
+	}
+
+	log.Printf("Reloaded config from %s", path)
+	if len(restart) > 0 {
+		log.Printf("Restart netclip to apply changes to: %s", strings.Join(restart, ", "))
+	}
+}
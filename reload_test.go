@@ -0,0 +1,153 @@
+package netclip_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// postClip saves a clip through the app's routes and returns the status
+func postClip(app http.Handler, text string) int {
+	req := httptest.NewRequest("POST", "/api/clips", strings.NewReader(`{"text": "`+text+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestReloadAppliesLiveSettings(t *testing.T) {
+	app := newApp(t)
+	assert.Equal(t, http.StatusCreated, postClip(app, "before"))
+
+	restart, err := app.Reload(netclip.Config{Mode: netclip.ModeReadOnly})
+	assert.NoError(t, err)
+	assert.Empty(t, restart)
+	assert.Equal(t, http.StatusForbidden, postClip(app, "read-only"))
+
+	// Clips survive the reload
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "before")
+
+	// httptest requests come from 192.0.2.1
+	_, err = app.Reload(netclip.Config{Access: netclip.AccessConfig{Write: netclip.AccessRule{Deny: []string{"192.0.2.0/24"}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, postClip(app, "denied"))
+
+	_, err = app.Reload(netclip.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, postClip(app, "after"))
+}
+
+func TestReloadReportsRestartSettings(t *testing.T) {
+	app := newApp(t)
+
+	restart, err := app.Reload(netclip.Config{
+		Port:      "4000",
+		DataFile:  filepath.Join(t.TempDir(), "clips.json"),
+		Listeners: []netclip.ListenerConfig{{Port: "4001"}},
+		Mode:      netclip.ModeWriteOnly,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"port", "data_file", "listeners"}, restart)
+
+	// The live setting still took effect
+	req := httptest.NewRequest("GET", "/raw/1", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	app := newApp(t)
+
+	_, err := app.Reload(netclip.Config{Mode: "sideways"})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusCreated, postClip(app, "still normal"))
+}
+
+func TestWatchConfig(t *testing.T) {
+	interval := netclip.ConfigCheckInterval
+	netclip.ConfigCheckInterval = 10 * time.Millisecond
+	defer func() { netclip.ConfigCheckInterval = interval }()
+
+	path := filepath.Join(t.TempDir(), "netclip.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("mode: normal\n"), 0600))
+
+	app := newApp(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reload := make(chan os.Signal, 1)
+	go app.WatchConfig(ctx, []string{path}, reload, func() (netclip.Config, error) {
+		return netclip.LoadConfig(path)
+	})
+
+	// A signal reloads the file
+	assert.NoError(t, os.WriteFile(path, []byte("mode: read-only\n"), 0600))
+	reload <- syscall.SIGHUP
+	assert.Eventually(t, func() bool {
+		return postClip(app, "signalled") == http.StatusForbidden
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// So does editing it
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.WriteFile(path, []byte("mode: normal\n"), 0600))
+	assert.NoError(t, os.Chtimes(path, later, later))
+	assert.Eventually(t, func() bool {
+		return postClip(app, "edited") == http.StatusCreated
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWatchConfigIncludes(t *testing.T) {
+	interval := netclip.ConfigCheckInterval
+	netclip.ConfigCheckInterval = 10 * time.Millisecond
+	defer func() { netclip.ConfigCheckInterval = interval }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "netclip.yml")
+	included := filepath.Join(dir, "conf.d", "mode.yml")
+	writeFile(t, path, "include: conf.d/*.yml\n")
+	writeFile(t, included, "mode: normal\n")
+
+	app := newApp(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reload := make(chan os.Signal, 1)
+	go app.WatchConfig(ctx, []string{path}, reload, func() (netclip.Config, error) {
+		return netclip.LoadConfig(path)
+	})
+
+	// A signal reads the included files too
+	writeFile(t, included, "mode: read-only\n")
+	reload <- syscall.SIGHUP
+	assert.Eventually(t, func() bool {
+		return postClip(app, "signalled") == http.StatusForbidden
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Editing an included file reloads
+	later := time.Now().Add(time.Minute)
+	writeFile(t, included, "mode: normal\n")
+	assert.NoError(t, os.Chtimes(included, later, later))
+	assert.Eventually(t, func() bool {
+		return postClip(app, "edited") == http.StatusCreated
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// So does a new file that matches the glob
+	writeFile(t, filepath.Join(dir, "conf.d", "zz-mode.yml"), "mode: read-only\n")
+	assert.Eventually(t, func() bool {
+		return postClip(app, "added") == http.StatusForbidden
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,64 @@
+package netclip
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// tailscaleAuthKeyEnv is the environment variable Tailscale's own tools read
+// an auth key from
+const tailscaleAuthKeyEnv = "TS_AUTHKEY"
+
+// ReadSecretFile reads a secret, like a key or token, from a file such as a
+// systemd credential or Docker secret. Surrounding whitespace is trimmed.
+func ReadSecretFile(path string) (string, error) {
+	data, err := readSecretFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readSecretFile reads a file of secrets. Environment variables in the path
+// are expanded, so it can point into $CREDENTIALS_DIRECTORY. A file any
+// user can read is logged, since the secret isn't much of one.
+func readSecretFile(path string) ([]byte, error) {
+	path = os.ExpandEnv(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if worldReadable(info.Mode()) {
+		log.Printf("Warning: any user can read %s. Restrict it with chmod o-r %s", path, path)
+	}
+	return io.ReadAll(f)
+}
+
+// worldReadable reports whether a file's permissions let every user read
+// it. Windows doesn't use these permission bits.
+func worldReadable(mode fs.FileMode) bool {
+	return runtime.GOOS != "windows" && mode.Perm()&0004 != 0
+}
+
+// AuthKey returns the key for joining the tailnet, from TS_AUTHKEY or else
+// auth_key_file. It's empty when neither is set, and tsnet asks for a login
+// instead.
+func (c TailscaleConfig) AuthKey() (string, error) {
+	if key := os.Getenv(tailscaleAuthKeyEnv); key != "" {
+		return key, nil
+	}
+	if c.AuthKeyFile == "" {
+		return "", nil
+	}
+	return ReadSecretFile(c.AuthKeyFile)
+}
@@ -0,0 +1,72 @@
+package netclip_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLog collects what's logged until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestReadSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("  tskey-auth-123\n"), 0600))
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	logged := captureLog(t)
+
+	secret, err := netclip.ReadSecretFile("${CREDENTIALS_DIRECTORY}/token")
+	assert.NoError(t, err)
+	assert.Equal(t, "tskey-auth-123", secret)
+	assert.Empty(t, logged.String())
+
+	_, err = netclip.ReadSecretFile(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestReadSecretFileWarnsWhenWorldReadable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't use Unix permissions")
+	}
+	path := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(path, []byte("secret"), 0600))
+	assert.NoError(t, os.Chmod(path, 0644))
+	logged := captureLog(t)
+
+	secret, err := netclip.ReadSecretFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", secret)
+	assert.Contains(t, logged.String(), "any user can read "+path)
+}
+
+func TestTailscaleAuthKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ts-auth-key")
+	assert.NoError(t, os.WriteFile(path, []byte("tskey-from-file\n"), 0600))
+	config := netclip.TailscaleConfig{AuthKeyFile: path}
+
+	key, err := config.AuthKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "tskey-from-file", key)
+
+	t.Setenv("TS_AUTHKEY", "tskey-from-env")
+	key, err = config.AuthKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "tskey-from-env", key)
+
+	t.Setenv("TS_AUTHKEY", "")
+	key, err = netclip.TailscaleConfig{}.AuthKey()
+	assert.NoError(t, err)
+	assert.Empty(t, key)
+}
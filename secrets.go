@@ -0,0 +1,45 @@
+package netclip
+
+import (
+	"regexp"
+	"time"
+)
+
+// SecretScanConfig controls the check for secrets in new clips
+type SecretScanConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// FlaggedTTL makes clips that look like they contain secrets expire
+	// after this long. Zero keeps them until they're deleted.
+	FlaggedTTL time.Duration `yaml:"flagged_ttl"`
+}
+
+// secretPattern is a kind of secret and how to spot it
+type secretPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// secretPatterns are checked against every new clip. They're deliberately
+// specific so everyday text doesn't get flagged.
+var secretPatterns = []secretPattern{
+	{"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret key", regexp.MustCompile(`(?i)aws.{0,20}secret.{0,20}['"=:\s][A-Za-z0-9/+]{40}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{"GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`)},
+	{"Tailscale key", regexp.MustCompile(`\btskey-[a-z]+-[A-Za-z0-9-]{10,}\b`)},
+}
+
+// ScanSecrets returns the kinds of secrets that appear in the text
+func ScanSecrets(text string) []string {
+	var found []string
+	for _, pattern := range secretPatterns {
+		if pattern.re.MatchString(text) {
+			found = append(found, pattern.name)
+		}
+	}
+	return found
+}
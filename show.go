@@ -0,0 +1,126 @@
+package netclip
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where a setting's value came from
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// maskedSecret stands in for secrets when the config is shown
+const maskedSecret = "********"
+
+// SettingSources tells where each setting's value came from: one of the
+// config files, lowest precedence first, a NETCLIP_* environment variable,
+// or one of flagSettings, the settings given on the command line. Settings
+// from a file are marked with its path, like "file /etc/netclip/netclip.yml".
+// Settings missing from the map have their defaults.
+func SettingSources(paths []string, flagSettings []string) (map[string]string, error) {
+	sources := make(map[string]string)
+
+	files, err := readConfigFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.root == nil {
+			continue
+		}
+		for _, setting := range nodeSettings(file.root, "") {
+			sources[setting] = SourceFile + " " + file.path
+		}
+	}
+
+	var config Config
+	for _, setting := range configFields(reflect.ValueOf(&config).Elem(), "") {
+		if os.Getenv(envName(setting.path)) != "" {
+			sources[setting.path] = SourceEnv
+		}
+	}
+
+	for _, setting := range flagSettings {
+		sources[setting] = SourceFlag
+	}
+	return sources, nil
+}
+
+// nodeSettings lists the settings a YAML mapping sets
+func nodeSettings(node *yaml.Node, prefix string) []string {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var settings []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		path := prefix + node.Content[i].Value
+		if value := node.Content[i+1]; value.Kind == yaml.MappingNode {
+			settings = append(settings, nodeSettings(value, path+".")...)
+		} else {
+			settings = append(settings, path)
+		}
+	}
+	return settings
+}
+
+// MarshalConfig writes the config as YAML with secrets masked and each
+// setting's source from SettingSources as a comment
+func MarshalConfig(config Config, sources map[string]string) ([]byte, error) {
+	if config.Encryption.Key != "" {
+		config.Encryption.Key = maskedSecret
+	}
+	if len(config.Encryption.PreviousKeys) > 0 {
+		keys := make([]string, len(config.Encryption.PreviousKeys))
+		for i := range keys {
+			keys[i] = maskedSecret
+		}
+		config.Encryption.PreviousKeys = keys
+	}
+
+	var root yaml.Node
+	if err := root.Encode(config); err != nil {
+		return nil, err
+	}
+	commentSources(&root, "", sources)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func commentSources(node *yaml.Node, prefix string, sources map[string]string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := prefix + key.Value
+		if value.Kind == yaml.MappingNode {
+			commentSources(value, path+".", sources)
+			continue
+		}
+
+		source := sources[path]
+		if source == "" {
+			source = SourceDefault
+		}
+		// Comments on block lists have to go on the key to stay on its line
+		if value.Kind == yaml.SequenceNode && len(value.Content) > 0 {
+			key.LineComment = source
+		} else {
+			value.LineComment = source
+		}
+	}
+}
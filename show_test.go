@@ -0,0 +1,76 @@
+package netclip_test
+
+import (
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingSources(t *testing.T) {
+	path := writeConfig(t, `port: "4000"
+mode: read-only
+tailscale:
+  hostname: clips
+access:
+  write:
+    allow: ["192.168.1.0/24"]
+listeners:
+  - port: "4001"
+`)
+	t.Setenv("NETCLIP_MODE", "normal")
+	t.Setenv("NETCLIP_DATA_FILE", "/var/lib/netclip/clips.json")
+
+	sources, err := netclip.SettingSources([]string{path}, []string{"port", "tailscale.enabled"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"port":               netclip.SourceFlag,
+		"mode":               netclip.SourceEnv,
+		"data_file":          netclip.SourceEnv,
+		"tailscale.hostname": "file " + path,
+		"tailscale.enabled":  netclip.SourceFlag,
+		"access.write.allow": "file " + path,
+		"listeners":          "file " + path,
+	}, sources)
+
+	sources, err = netclip.SettingSources(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"mode":      netclip.SourceEnv,
+		"data_file": netclip.SourceEnv,
+	}, sources)
+}
+
+func TestMarshalConfig(t *testing.T) {
+	config := netclip.Config{
+		Port: "4000",
+		Encryption: netclip.EncryptionConfig{
+			Key:          "c2VjcmV0IGtleSB0aGF0IHNob3VsZCBub3Qgc2hvdw==",
+			PreviousKeys: []string{"b2xkIGtleQ=="},
+		},
+		Listeners: []netclip.ListenerConfig{{Port: "4001"}},
+	}
+
+	out, err := netclip.MarshalConfig(config, map[string]string{
+		"port":           netclip.SourceFlag,
+		"encryption.key": netclip.SourceEnv,
+		"listeners":      netclip.SourceFile,
+	})
+	assert.NoError(t, err)
+
+	s := string(out)
+	assert.Contains(t, s, `port: "4000" # flag`)
+	assert.Contains(t, s, `cert_file: "" # default`)
+	assert.Contains(t, s, "  key: '********' # env\n")
+	assert.Contains(t, s, "  previous_keys: # default\n    - '********'\n")
+	assert.Contains(t, s, "listeners: # file\n  - name: \"\"")
+	assert.NotContains(t, s, "c2VjcmV0")
+	assert.NotContains(t, s, "b2xkIGtleQ")
+
+	// The output is still a config file
+	loaded, err := netclip.LoadConfig(writeConfig(t, s))
+	assert.NoError(t, err)
+	assert.Equal(t, "4000", loaded.Port)
+	assert.Equal(t, "4001", loaded.Listeners[0].Port)
+}
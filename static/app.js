@@ -1,30 +1,154 @@
 function addButtons() {
-  var snippets = document.querySelectorAll('.snippet pre');
+  // Masked clips get theirs once they're revealed
+  var snippets = document.querySelectorAll('.snippet:not(.masked) pre');
   var numberOfSnippets = snippets.length;
 
 
   for (var i = 0; i < numberOfSnippets; i++) {
-    var p = snippets[i].parentElement;
-    var b = document.createElement("button");
-    b.classList.add('btn-copy')
-    b.innerText="Copy";
-
-    b.addEventListener("click", function () {
-      this.innerText = 'Copying..';
-      code = this.nextSibling.innerText;
-      console.log(this.nextSibling);
-      navigator.clipboard.writeText(code);
-      this.innerText = 'Copied!';
-      var that = this;
-      setTimeout(function () {
-        that.innerText = 'Copy';
-      }, 1000)
-    });
-    p.prepend(b)
+    addButton(snippets[i]);
   }
 }
 
+function addButton(pre) {
+  var p = pre.parentElement;
+  var b = document.createElement("button");
+  b.classList.add('btn-copy')
+  b.innerText="Copy";
+
+  b.addEventListener("click", function () {
+    this.innerText = 'Copying..';
+    code = this.nextSibling.innerText;
+    navigator.clipboard.writeText(code);
+    this.innerText = 'Copied!';
+    var that = this;
+    setTimeout(function () {
+      that.innerText = 'Copy';
+    }, 1000)
+  });
+  p.prepend(b)
+}
+
 addButtons();
 
+// Flagged clips aren't in the page until they're revealed, so they can't be
+// copied or read from the markup by accident
+function setupMaskedClips() {
+  document.querySelectorAll('.snippet.masked').forEach(function (el) {
+    el.addEventListener('click', function reveal() {
+      el.removeEventListener('click', reveal);
+      fetch('/raw/' + encodeURIComponent(el.dataset.key))
+        .then(function (res) {
+          if (!res.ok) {
+            throw new Error(res.statusText);
+          }
+          return res.text();
+        })
+        .then(function (text) {
+          var pre = el.querySelector('pre');
+          pre.innerText = text;
+          el.classList.remove('masked');
+          addButton(pre);
+        })
+        .catch(function (err) {
+          el.querySelector('pre').innerText = 'Could not load this clip: ' + err.message;
+          el.classList.remove('masked');
+        });
+    });
+  });
+}
+
+setupMaskedClips();
+
+// End-to-end encrypted clips use AES-256-GCM. The stored clip is
+// "e2e:v1:" followed by the base64url nonce and ciphertext, and the key
+// lives in the link's fragment so it never reaches the server. This matches
+// the format in e2e.go.
+var E2E_PREFIX = 'e2e:v1:';
+
+function toBase64Url(bytes) {
+  var s = '';
+  bytes.forEach(function (b) { s += String.fromCharCode(b); });
+  return btoa(s).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function fromBase64Url(str) {
+  str = str.replace(/-/g, '+').replace(/_/g, '/');
+  while (str.length % 4) {
+    str += '=';
+  }
+  return Uint8Array.from(atob(str), function (c) { return c.charCodeAt(0); });
+}
+
+async function encryptClip(text) {
+  var keyBytes = crypto.getRandomValues(new Uint8Array(32));
+  var nonce = crypto.getRandomValues(new Uint8Array(12));
+  var key = await crypto.subtle.importKey('raw', keyBytes, 'AES-GCM', false, ['encrypt']);
+  var ciphertext = new Uint8Array(await crypto.subtle.encrypt({ name: 'AES-GCM', iv: nonce }, key, new TextEncoder().encode(text)));
+
+  var sealed = new Uint8Array(nonce.length + ciphertext.length);
+  sealed.set(nonce);
+  sealed.set(ciphertext, nonce.length);
+
+  return { clip: E2E_PREFIX + toBase64Url(sealed), key: toBase64Url(keyBytes) };
+}
+
+async function decryptClip(clip, keyText) {
+  var sealed = fromBase64Url(clip.slice(E2E_PREFIX.length));
+  var key = await crypto.subtle.importKey('raw', fromBase64Url(keyText), 'AES-GCM', false, ['decrypt']);
+  var plaintext = await crypto.subtle.decrypt({ name: 'AES-GCM', iv: sealed.slice(0, 12) }, key, sealed.slice(12));
+  return new TextDecoder().decode(plaintext);
+}
+
+function setupEncryptedSave() {
+  var form = document.querySelector('form[action="save"]');
+  if (!form) {
+    return;
+  }
+
+  form.addEventListener('submit', async function (e) {
+    if (!form.elements.e2e || !form.elements.e2e.checked) {
+      return;
+    }
+    e.preventDefault();
+
+    if (!window.crypto || !crypto.subtle) {
+      alert('End-to-end encryption needs a secure (HTTPS) connection.');
+      return;
+    }
+
+    var encrypted = await encryptClip(form.elements.text.value);
+    var response = await fetch('/api/clips', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ text: encrypted.clip, passphrase: form.elements.passphrase.value })
+    });
+    if (!response.ok) {
+      alert('Could not save clip: ' + await response.text());
+      return;
+    }
+
+    var saved = await response.json();
+    window.location.href = saved.url + '#' + encrypted.key;
+  });
+}
+
+function showEncryptedClips() {
+  document.querySelectorAll('pre.e2e').forEach(async function (el) {
+    var status = el.parentElement.nextElementSibling;
+    var key = window.location.hash.slice(1);
+    if (!key) {
+      status.innerText = 'This clip is end-to-end encrypted and this link has no key.';
+      return;
+    }
+
+    try {
+      el.innerText = await decryptClip(el.dataset.ciphertext, key);
+      status.innerText = 'This clip is end-to-end encrypted. Share this page\'s full link to share it.';
+    } catch (err) {
+      status.innerText = 'This clip could not be decrypted with the key in this link.';
+    }
+  });
+}
 
-document.querySelectorAll("pre").forEach(el => el.innerText = el.innerHTML);
+setupEncryptedSave();
+showEncryptedClips();
@@ -0,0 +1,145 @@
+package netclip_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"netclip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// socketPath returns a path for a test socket. Socket paths have a short
+// length limit, so it avoids the long names of t.TempDir.
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "netclip")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "netclip.sock")
+}
+
+func TestUnixServer(t *testing.T) {
+	path := socketPath(t)
+
+	// A socket left over from an earlier run is replaced
+	stale, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, stale.Close())
+
+	server := &netclip.UnixServer{Path: path, Mode: 0660}
+	ln, err := server.Listen(context.Background())
+	assert.NoError(t, err)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+	// Nothing is left of the private directory the socket was made in
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "netclip.sock", entries[0].Name())
+	}
+
+	serveErr := make(chan error, 1)
+	app := newApp(t)
+	go func() {
+		serveErr <- server.Serve(ln, app)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	// Socket clients count as local for reads
+	resp, err := client.Get("http://netclip/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// But not for the admin pages, since a proxy might be in front
+	resp, err = client.Get("http://netclip/admin/audit")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	assert.NoError(t, server.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-serveErr, http.ErrServerClosed)
+
+	// The socket is removed on shutdown
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestUnixServerAdminNeedsRule(t *testing.T) {
+	path := socketPath(t)
+	server := &netclip.UnixServer{Path: path}
+	ln, err := server.Listen(context.Background())
+	assert.NoError(t, err)
+
+	app, err := netclip.NewApp(netclip.Config{
+		Access: netclip.AccessConfig{Admin: netclip.AccessRule{Allow: []string{"127.0.0.1"}}},
+	})
+	assert.NoError(t, err)
+	defer app.Close()
+	go server.Serve(ln, app)
+	defer server.Shutdown(context.Background())
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	resp, err := client.Get("http://netclip/admin/audit")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestUnixServerKeepsSocketInUse(t *testing.T) {
+	path := socketPath(t)
+	running, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	defer running.Close()
+
+	server := &netclip.UnixServer{Path: path}
+	_, err = server.Listen(context.Background())
+	assert.ErrorContains(t, err, "in use by another server")
+
+	// The running server still gets its connections
+	go func() {
+		if conn, err := running.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
+
+func TestUnixServerKeepsOtherFiles(t *testing.T) {
+	path := socketPath(t)
+	assert.NoError(t, os.WriteFile(path, []byte("not a socket"), 0600))
+
+	server := &netclip.UnixServer{Path: path}
+	_, err := server.Listen(context.Background())
+	assert.Error(t, err)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "not a socket", string(data))
+}